@@ -1,33 +1,111 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"time"
 
 	"securemonitor/internal/api"
 	"securemonitor/internal/config"
+	"securemonitor/internal/firewall"
 	"securemonitor/internal/monitor"
 	"securemonitor/internal/storage"
 )
 
+// configuration file, relative to the working directory.
+const configPath = "config.json"
+
 //entrypoint for the SecureMonitor daemon process.
 func main() {
 	log.Println("securemonitor starting up")
 
 	//load configuration from disk.
-	cfg, err := config.Load("config.json")
+	cfg, err := config.Load(configPath)
 	if err != nil {
 		log.Fatalf("failed to load config: %v", err)
 	}
 
-	//preload any previously blocked IP addresses.
-	storage.LoadBlockedFromFile(cfg.BlockedIPsFile)
-	log.Printf("loaded blocked ip list from %s", cfg.BlockedIPsFile)
+	//select the firewall backend and make sure it is usable.
+	fw, err := firewall.New(cfg)
+	if err != nil {
+		log.Fatalf("failed to set up firewall: %v", err)
+	}
+	if err := fw.Check(); err != nil {
+		log.Printf("firewall backend %s is not healthy: %v", fw.Name(), err)
+	}
+	firewall.SetBackend(fw)
+	log.Printf("using firewall backend %s", fw.Name())
+
+	//open the store for blocks, strikes, alerts, logs and the audit
+	//trail (migrating the old ip list).
+	store, err := openStore(cfg)
+	if err != nil {
+		log.Fatalf("failed to open store: %v", err)
+	}
+	defer store.Close()
+
+	//strikes fade according to the configured window and decay.
+	store.SetStrikePolicy(storage.StrikePolicy{
+		Window: time.Duration(cfg.StrikeWindowDays) * 24 * time.Hour,
+		Decay:  time.Duration(cfg.StrikeDecayDays) * 24 * time.Hour,
+	})
+
+	//build the engine; it starts the workers that apply firewall changes
+	//off the scan loop.
+	engine := monitor.New(cfg, store)
 
 	//start the http API server in the background.
 	log.Println("starting api server on :9000")
-	api.StartServer(":9000")
+	api.NewServer(cfg, engine).Start(":9000")
 
 	//enter the main monitoring loop (blocking call).
 	log.Println("entering monitoring loop")
-	monitor.RunLoop(cfg)
+	engine.Run()
+}
+
+// returns the store selected by the configuration.
+func openStore(cfg config.Config) (storage.Store, error) {
+	switch cfg.Store {
+	case "memory":
+		log.Println("using in-memory store, nothing survives a restart")
+		return storage.NewMemoryStore(), nil
+	case "", "disk":
+	default:
+		return nil, fmt.Errorf("unknown store %q", cfg.Store)
+	}
+
+	auditFile := cfg.AuditLogFile
+	if auditFile == "" {
+		auditFile = "audit.jsonl"
+	}
+	store, err := storage.OpenDiskStore(storage.DiskOptions{
+		BlockDB:       cfg.BlockDBPath(),
+		LegacyBlocked: cfg.BlockedIPsFile,
+		Alerts:        alertStoreOptions(cfg),
+		AuditLog:      auditFile,
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("loaded block database from %s", cfg.BlockDBPath())
+	return store, nil
+}
+
+// returns the alert history settings, with defaults for missing values.
+func alertStoreOptions(cfg config.Config) storage.AlertStoreOptions {
+	opts := storage.AlertStoreOptions{
+		Dir:      cfg.AlertStoreDir,
+		MaxAge:   time.Duration(cfg.AlertRetentionDays) * 24 * time.Hour,
+		MaxBytes: int64(cfg.AlertRetentionMB) << 20,
+	}
+	if opts.Dir == "" {
+		opts.Dir = "alerts"
+	}
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = 30 * 24 * time.Hour
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = 256 << 20
+	}
+	return opts
 }
@@ -0,0 +1,149 @@
+// internal/api/audit.go
+package api
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"securemonitor/internal/ipaddr"
+	"securemonitor/internal/storage"
+)
+
+// returns the operator identity set by the authenticating proxy in the
+// configured header, or "anonymous". The header is only believed from
+// the proxies listed in audit_trusted_proxies (loopback by default):
+// anyone else could set it to any name.
+func (srv *Server) actorFromRequest(r *http.Request) string {
+	cfg := srv.cfg
+	if !trustedProxy(ipaddr.Canonical(r.RemoteAddr), cfg.AuditTrustedProxies) {
+		return "anonymous"
+	}
+
+	header := cfg.AuditActorHeader
+	if header == "" {
+		header = "X-Forwarded-User"
+	}
+	if actor := strings.TrimSpace(r.Header.Get(header)); actor != "" {
+		return actor
+	}
+	return "anonymous"
+}
+
+// reports whether peer is one of the trusted proxies (addresses or CIDR
+// ranges), or a loopback address when none are configured.
+func trustedProxy(peer string, proxies []string) bool {
+	addr, ok := ipaddr.Parse(peer)
+	if !ok {
+		return false
+	}
+	if len(proxies) == 0 {
+		return addr.IsLoopback()
+	}
+
+	for _, p := range proxies {
+		p = strings.TrimSpace(p)
+		if prefix, err := netip.ParsePrefix(p); err == nil {
+			if prefix.Masked().Contains(addr) {
+				return true
+			}
+			continue
+		}
+		if a, ok := ipaddr.Parse(p); ok && a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the peer address of the request, followed by the
+// X-Forwarded-For chain when a proxy sent one.
+func sourceFromRequest(r *http.Request) string {
+	source := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		source = host
+	}
+	if xff := strings.TrimSpace(r.Header.Get("X-Forwarded-For")); xff != "" {
+		source += " (forwarded for " + xff + ")"
+	}
+	return source
+}
+
+// records an operator action taken through r. The reason comes from the
+// "reason" query parameter unless given.
+func (srv *Server) audit(r *http.Request, action, target string, before, after any, reason string) {
+	if reason == "" {
+		reason = r.URL.Query().Get("reason")
+	}
+	srv.store.AddAudit(storage.AuditRecord{
+		Actor:  srv.actorFromRequest(r),
+		Source: sourceFromRequest(r),
+		Action: action,
+		Target: target,
+		Before: before,
+		After:  after,
+		Reason: reason,
+	})
+}
+
+// returns the audit trail, oldest first. Filters: since, until
+// (RFC 3339), actor, action, target; limit (default 100, max 1000) picks
+// the newest matches and X-Next-Cursor/cursor page back like /api/alerts.
+func (srv *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	q := storage.AuditQuery{
+		Actor:  params.Get("actor"),
+		Action: params.Get("action"),
+		Target: params.Get("target"),
+		Limit:  100,
+	}
+
+	var err error
+	if v := params.Get("since"); v != "" {
+		if q.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "invalid since parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := params.Get("until"); v != "" {
+		if q.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "invalid until parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1000 {
+			http.Error(w, "invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		q.Limit = n
+	}
+	if v := params.Get("cursor"); v != "" {
+		if q.Before, err = strconv.ParseUint(v, 10, 64); err != nil {
+			http.Error(w, "invalid cursor parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	records, next, err := srv.store.QueryAudit(q)
+	if err != nil {
+		http.Error(w, "audit log error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if records == nil {
+		records = []storage.AuditRecord{}
+	}
+	if next > 0 {
+		w.Header().Set("X-Next-Cursor", strconv.FormatUint(next, 10))
+	}
+	writeJSON(w, http.StatusOK, records)
+}
@@ -2,24 +2,36 @@
 package api
 
 import (
-	"net"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
 	"securemonitor/internal/firewall"
+	"securemonitor/internal/ipaddr"
 	"securemonitor/internal/monitor"
 	"securemonitor/internal/storage"
 )
 
 //  is the aggregated payload returned by /api/dashboard.
 type DashboardSnapshot struct {
-	Status  map[string]string `json:"status"`
-	Stats   map[string]int    `json:"stats"`
-	Logs    []string          `json:"logs"`
-	Alerts  []storage.Alert   `json:"alerts"`
-	Blocked []string          `json:"blocked"`
+	Status     map[string]string `json:"status"`
+	Stats      map[string]int    `json:"stats"`
+	Logs       []string          `json:"logs"`
+	Alerts     []storage.Alert   `json:"alerts"`
+	Blocked    []string          `json:"blocked"`
+	WouldBlock []string          `json:"would_block"` // dry-run decisions
+}
+
+// returns only the IPs of the dry-run decisions.
+func (srv *Server) shadowIPs() []string {
+	entries := srv.store.ListShadowBlocked()
+	ips := make([]string, 0, len(entries))
+	for _, e := range entries {
+		ips = append(ips, e.IP)
+	}
+	return ips
 }
 
 // returns a simple status block for health checks and the dashboard.
@@ -34,52 +46,202 @@ func buildStatusSnapshot() map[string]string {
 func clientIPFromRequest(r *http.Request) string {
 	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
 		parts := strings.Split(xff, ",")
-		return strings.TrimSpace(parts[0])
+		if ip := ipaddr.Canonical(parts[0]); ip != "" {
+			return ip
+		}
 	}
 
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
-	}
-	return host
+	// RemoteAddr is "ip:port" or "[v6]:port".
+	return ipaddr.Canonical(r.RemoteAddr)
 }
 
 // ----------- JSON handlers -----------
 
-func handleStatus(w http.ResponseWriter, r *http.Request) {
+func (srv *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, buildStatusSnapshot())
 }
 
-func handleLogs(w http.ResponseWriter, r *http.Request) {
-	logs := storage.GetLogs()
-	writeJSON(w, http.StatusOK, logs)
+// returns the recent internal events. By default they are rendered as
+// text lines, as before; format=json returns the structured entries.
+// Filters: since (RFC 3339), level, component, event, ip, limit.
+func (srv *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q := storage.LogQuery{
+		Level:     params.Get("level"),
+		Component: params.Get("component"),
+		Event:     params.Get("event"),
+	}
+	if v := params.Get("ip"); v != "" {
+		q.IP = ipaddr.CanonicalTarget(v)
+		if q.IP == "" {
+			http.Error(w, "invalid ip parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := params.Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "invalid since parameter", http.StatusBadRequest)
+			return
+		}
+		q.Since = since
+	}
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		q.Limit = n
+	}
+
+	entries := srv.store.QueryLogs(q)
+	if params.Get("format") == "json" {
+		writeJSON(w, http.StatusOK, entries)
+		return
+	}
+
+	lines := make([]string, len(entries))
+	for i, e := range entries {
+		lines[i] = e.String()
+	}
+	writeJSON(w, http.StatusOK, lines)
 }
 
-func handleBlocked(w http.ResponseWriter, r *http.Request) {
-	ips := storage.ListBlocked()
+// returns the blocked IPs. detail=1 returns the entries instead, with
+// their scope, expiry and firewall state (pending, active, failed or
+// removing).
+func (srv *Server) handleBlocked(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("detail") == "1" {
+		entries := srv.store.ListBlockedEntries()
+		if entries == nil {
+			entries = []storage.BlockedEntry{}
+		}
+		writeJSON(w, http.StatusOK, entries)
+		return
+	}
+
+	ips := srv.store.ListBlocked()
 	writeJSON(w, http.StatusOK, ips)
 }
 
-func handleUnblock(w http.ResponseWriter, r *http.Request) {
+// returns the blocks that dry-run mode decided but did not apply.
+func (srv *Server) handleWouldBlock(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, srv.store.ListShadowBlocked())
+}
+
+// returns the rate-limited sources.
+func (srv *Server) handleLimited(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, srv.store.ListLimited())
+}
+
+func (srv *Server) handleUnblock(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	ip := r.URL.Query().Get("ip")
-	if ip == "" {
+	raw := r.URL.Query().Get("ip")
+	if raw == "" {
 		http.Error(w, "missing ip parameter", http.StatusBadRequest)
 		return
 	}
+	ip := ipaddr.CanonicalTarget(raw)
+	if ip == "" {
+		http.Error(w, "invalid ip parameter", http.StatusBadRequest)
+		return
+	}
 
-	firewall.UnblockIP(ip)
-	storage.RemoveBlocked(ip)
-	storage.AddLog("[FIREWALL] unblocked via dashboard: " + ip)
+	before := srv.blockState(ip)
 
-	w.WriteHeader(http.StatusNoContent)
+	// the entry stays as "removing" until the queue has applied it.
+	srv.engine.RequestUnblock(ip)
+	srv.store.RemoveShadowBlocked(ip)
+	srv.store.Log(storage.LogEntry{
+		Component: "api",
+		Event:     "unblock_requested",
+		IP:        ip,
+		Message:   "[FIREWALL] unblock requested via dashboard: " + ip,
+	})
+	srv.audit(r, "unblock", ip, before, srv.blockState(ip), "")
+
+	w.WriteHeader(http.StatusAccepted)
 }
 
-func handleStats(w http.ResponseWriter, r *http.Request) {
+// is the state of an address audited around an unblock.
+type addrBlockState struct {
+	Block      *storage.BlockedEntry `json:"block,omitempty"`
+	Limit      *storage.BlockedEntry `json:"limit,omitempty"`
+	WouldBlock *storage.BlockedEntry `json:"would_block,omitempty"`
+}
+
+// returns the current block, limit and dry-run block of ip.
+func (srv *Server) blockState(ip string) addrBlockState {
+	var s addrBlockState
+	if e, ok := srv.store.GetBlocked(ip); ok {
+		s.Block = &e
+	}
+	if e, ok := srv.store.GetLimited(ip); ok {
+		s.Limit = &e
+	}
+	if e, ok := srv.store.GetShadowBlocked(ip); ok {
+		s.WouldBlock = &e
+	}
+	return s
+}
+
+// returns everything known about one address.
+func (srv *Server) handleIPDossier(w http.ResponseWriter, r *http.Request) {
+	addr, ok := ipaddr.Parse(r.PathValue("ip"))
+	if !ok {
+		http.Error(w, "invalid ip", http.StatusBadRequest)
+		return
+	}
+
+	d, err := srv.engine.BuildDossier(addr.String())
+	if err != nil {
+		http.Error(w, "alert history error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
+
+// returns the last reconciliation report; POST runs a new one first.
+func (srv *Server) handleFirewallDrift(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, srv.engine.LastDrift())
+	case http.MethodPost:
+		writeJSON(w, http.StatusOK, srv.engine.ReconcileNow())
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// is the payload returned by /api/firewall/queue.
+type QueueSnapshot struct {
+	Backend string         `json:"backend"`
+	Pending int            `json:"pending"`
+	Failed  []firewall.Job `json:"failed"`
+}
+
+// reports queued firewall actions and those that ran out of retries.
+func (srv *Server) handleFirewallQueue(w http.ResponseWriter, r *http.Request) {
+	q := srv.engine.ActionQueue()
+	snap := QueueSnapshot{
+		Backend: firewall.Active().Name(),
+		Pending: q.Pending(),
+		Failed:  q.Failed(),
+	}
+	writeJSON(w, http.StatusOK, snap)
+}
+
+// reports how far behind the reading of each log is.
+func (srv *Server) handleIngest(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, srv.engine.TailBacklog())
+}
+
+func (srv *Server) handleStats(w http.ResponseWriter, r *http.Request) {
 	stats := map[string]int{
 		"ssh":    monitor.GetSSHCount(),
 		"ftp":    monitor.GetFTPCount(),
@@ -88,12 +250,69 @@ func handleStats(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, stats)
 }
 
-func handleAlerts(w http.ResponseWriter, r *http.Request) {
-	alerts := storage.GetAlerts()
+// returns alerts from the history, oldest first. Filters: since, until
+// (RFC 3339), service, severity, ip, host; limit (default 100, max 1000)
+// picks the newest matches. When older matches exist, the X-Next-Cursor
+// header holds the value to pass as cursor for the previous page.
+func (srv *Server) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	q, err := parseAlertQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	alerts, next, err := srv.store.QueryAlerts(q)
+	if err != nil {
+		http.Error(w, "alert history error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if alerts == nil {
+		alerts = []storage.Alert{}
+	}
+	if next > 0 {
+		w.Header().Set("X-Next-Cursor", strconv.FormatUint(next, 10))
+	}
 	writeJSON(w, http.StatusOK, alerts)
 }
 
-func handleDashboard(w http.ResponseWriter, r *http.Request) {
+// reads the alert filters from the query string.
+func parseAlertQuery(r *http.Request) (storage.AlertQuery, error) {
+	params := r.URL.Query()
+	q := storage.AlertQuery{
+		Service:  params.Get("service"),
+		Severity: params.Get("severity"),
+		IP:       params.Get("ip"),
+		Host:     params.Get("host"),
+		Limit:    100,
+	}
+
+	var err error
+	if v := params.Get("since"); v != "" {
+		if q.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return q, fmt.Errorf("invalid since parameter")
+		}
+	}
+	if v := params.Get("until"); v != "" {
+		if q.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return q, fmt.Errorf("invalid until parameter")
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1000 {
+			return q, fmt.Errorf("invalid limit parameter")
+		}
+		q.Limit = n
+	}
+	if v := params.Get("cursor"); v != "" {
+		if q.Before, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return q, fmt.Errorf("invalid cursor parameter")
+		}
+	}
+	return q, nil
+}
+
+func (srv *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
 	snap := DashboardSnapshot{
 		Status: buildStatusSnapshot(),
 		Stats: map[string]int{
@@ -101,9 +320,10 @@ func handleDashboard(w http.ResponseWriter, r *http.Request) {
 			"ftp":    monitor.GetFTPCount(),
 			"apache": monitor.GetApacheCount(),
 		},
-		Logs:    storage.GetLogs(),
-		Alerts:  storage.GetAlerts(),
-		Blocked: storage.ListBlocked(),
+		Logs:       srv.store.GetLogs(),
+		Alerts:     srv.store.GetAlerts(),
+		Blocked:    srv.store.ListBlocked(),
+		WouldBlock: srv.shadowIPs(),
 	}
 
 	writeJSON(w, http.StatusOK, snap)
@@ -113,7 +333,7 @@ func handleDashboard(w http.ResponseWriter, r *http.Request) {
 
 // handleSimulate allows injecting fake SSH/FTP/Apache events for testing.
 // It returns JSON by default (POST or Accept: application/json) or a small HTML view for GET in a browser.
-func handleSimulate(w http.ResponseWriter, r *http.Request) {
+func (srv *Server) handleSimulate(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost && r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -132,6 +352,10 @@ func handleSimulate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ip := clientIPFromRequest(r)
+	if ip == "" {
+		http.Error(w, "cannot determine client ip", http.StatusBadRequest)
+		return
+	}
 	now := time.Now()
 
 	switch kind {
@@ -146,7 +370,14 @@ func handleSimulate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	storage.AddLog("[SIM] scheduled " + strconv.Itoa(n) + " " + kind + " events from " + ip)
+	srv.store.Log(storage.LogEntry{
+		Component: "sim",
+		Event:     "simulate",
+		IP:        ip,
+		Fields:    storage.Fields{"kind": kind, "events": n},
+		Message:   "[SIM] scheduled " + strconv.Itoa(n) + " " + kind + " events from " + ip,
+	})
+	srv.audit(r, "simulate", ip, nil, map[string]any{"kind": kind, "events": n}, "")
 
 	resp := map[string]interface{}{
 		"ok":        true,
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"securemonitor/internal/storage"
+)
+
+func TestHandleBlocked(t *testing.T) {
+	store := storage.NewMemoryStore()
+	store.AddBlocked(storage.BlockedEntry{IP: "203.0.113.7", Service: "ssh", State: storage.BlockActive})
+	store.AddBlocked(storage.BlockedEntry{IP: "203.0.113.8", Service: "ftp", Ports: []string{"21/tcp"}, State: storage.BlockPending})
+	store.AddBlocked(storage.BlockedEntry{IP: "203.0.113.9", Service: "ssh", State: storage.BlockFailed})
+	srv := &Server{store: store}
+
+	get := func(target string, v any) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		srv.handleBlocked(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d", target, rec.Code)
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("GET %s: %v", target, err)
+		}
+	}
+
+	var ips []string
+	get("/api/blocked", &ips)
+	if len(ips) != 3 {
+		t.Errorf("blocked = %v, want 3 addresses", ips)
+	}
+
+	var entries []storage.BlockedEntry
+	get("/api/blocked?detail=1", &entries)
+	want := map[string]string{
+		"203.0.113.7": storage.BlockActive,
+		"203.0.113.8": storage.BlockPending,
+		"203.0.113.9": storage.BlockFailed,
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("entries = %+v, want %d", entries, len(want))
+	}
+	for _, e := range entries {
+		if e.State != want[e.IP] {
+			t.Errorf("%s: state = %q, want %q", e.IP, e.State, want[e.IP])
+		}
+		if e.IP == "203.0.113.8" && (e.Scope != "service" || len(e.Ports) != 1) {
+			t.Errorf("%s: scope = %q ports = %v, want the ftp port", e.IP, e.Scope, e.Ports)
+		}
+	}
+
+	srv.store = storage.NewMemoryStore()
+	var empty []storage.BlockedEntry
+	get("/api/blocked?detail=1", &empty)
+	if empty == nil || len(empty) != 0 {
+		t.Errorf("no blocks: entries = %v, want []", empty)
+	}
+}
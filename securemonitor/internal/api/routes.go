@@ -3,18 +3,26 @@ package api
 
 import "net/http"
 
-func registerRoutes(mux *http.ServeMux) {
+func (srv *Server) registerRoutes(mux *http.ServeMux) {
 	// API routes.
-	mux.HandleFunc("/api/status", handleStatus)
-	mux.HandleFunc("/api/logs", handleLogs)
-	mux.HandleFunc("/api/blocked", handleBlocked)
-	mux.HandleFunc("/api/unblock", handleUnblock)
-	mux.HandleFunc("/api/stats", handleStats)
-	mux.HandleFunc("/api/alerts", handleAlerts)
-	mux.HandleFunc("/api/dashboard", handleDashboard)
+	mux.HandleFunc("/api/status", srv.handleStatus)
+	mux.HandleFunc("/api/logs", srv.handleLogs)
+	mux.HandleFunc("/api/blocked", srv.handleBlocked)
+	mux.HandleFunc("/api/would-block", srv.handleWouldBlock)
+	mux.HandleFunc("/api/limited", srv.handleLimited)
+	mux.HandleFunc("/api/unblock", srv.handleUnblock)
+	mux.HandleFunc("/api/audit", srv.handleAudit)
+	mux.HandleFunc("/api/stats", srv.handleStats)
+	mux.HandleFunc("/api/alerts", srv.handleAlerts)
+	mux.HandleFunc("/api/alerts/export", srv.handleAlertsExport)
+	mux.HandleFunc("/api/dashboard", srv.handleDashboard)
+	mux.HandleFunc("GET /api/ip/{ip}", srv.handleIPDossier)
+	mux.HandleFunc("/api/firewall/drift", srv.handleFirewallDrift)
+	mux.HandleFunc("/api/firewall/queue", srv.handleFirewallQueue)
+	mux.HandleFunc("/api/ingest", srv.handleIngest)
 
 	// simulation endpoint for demo/testing.
-	mux.HandleFunc("/api/simulate", handleSimulate)
+	mux.HandleFunc("/api/simulate", srv.handleSimulate)
 
 	// static dashboard assets.
 	fs := http.FileServer(http.Dir("web"))
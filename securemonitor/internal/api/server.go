@@ -5,14 +5,30 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+
+	"securemonitor/internal/config"
+	"securemonitor/internal/monitor"
+	"securemonitor/internal/storage"
 )
 
+// Server serves the HTTP API of a running monitor engine.
+type Server struct {
+	cfg    config.Config // the configuration the daemon runs with
+	engine *monitor.Engine
+	store  storage.Store // shared with the engine
+}
+
+// builds the API server for the engine, which runs with cfg.
+func NewServer(cfg config.Config, engine *monitor.Engine) *Server {
+	return &Server{cfg: cfg, engine: engine, store: engine.Store()}
+}
+
 // boots the HTTP API on the provided address.
-func StartServer(addr string) {
+func (srv *Server) Start(addr string) {
 	mux := http.NewServeMux()
 
 	// registers all routes
-	registerRoutes(mux)
+	srv.registerRoutes(mux)
 
 	go func() {
 		log.Printf("api listening on %s", addr)
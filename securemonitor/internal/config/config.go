@@ -3,6 +3,7 @@ package config
 import (
 	"encoding/json"
 	"os"
+	"strings"
 )
 
 // holds runtime configuration loaded from a JSON file.
@@ -13,6 +14,16 @@ type Config struct {
 	ApacheErrorLogPath   string   `json:"apache_error_log_path"`
 	FTPLogPath           string   `json:"ftp_log_path"`
 
+	// systemd journal inputs, for services that log only to the journal.
+	JournalSources    []JournalSource `json:"journal_sources"`
+	JournalCursorFile string          `json:"journal_cursor_file"` // default journal_cursor.json
+
+	// syslog receiver, for hosts that forward their logs here. Messages
+	// are routed to the service parsers by program name.
+	SyslogUDP     string   `json:"syslog_udp"`     // listen address, e.g. ":514"; empty = off
+	SyslogTCP     string   `json:"syslog_tcp"`     // listen address, LF or octet-counted framing; empty = off
+	SyslogAllowed []string `json:"syslog_allowed"` // sender IPs or CIDRs; empty = loopback only
+
 	MaxFailures          int `json:"max_failures"` // global fallback
 
 	SSHMaxFailures       int `json:"ssh_max_failures"`
@@ -20,11 +31,126 @@ type Config struct {
 	ApacheErrorThreshold int `json:"apache_error_threshold"`
 
 	CheckIntervalSeconds int    `json:"check_interval_seconds"`
-	BlockedIPsFile       string `json:"blocked_ips_file"`
+	Store                string `json:"store"`            // disk (default) | memory (nothing survives a restart)
+	BlockedIPsFile       string `json:"blocked_ips_file"` // legacy IP list, migrated into block_db_file
+	BlockDBFile          string `json:"block_db_file"`    // default blocked_db.jsonl
+	TailStateFile        string `json:"tail_state_file"`  // where log offsets survive restarts, default tail_state.json
+	CatchUpHours         int    `json:"catch_up_hours"`   // how far back rotated logs are read after downtime, 0 = never
+	MaxReadMB            int    `json:"max_read_mb"`      // read per log and cycle, the rest waits; default 16
 	WhitelistFile        string `json:"whitelist_file"`
 
 	AutoUnblockMinutes     int  `json:"auto_unblock_minutes"`      // 0 = disabled
+	StrikeWindowDays       int  `json:"strike_window_days"`        // only strikes this recent count, 0 = all
+	StrikeDecayDays        int  `json:"strike_decay_days"`         // forgive one strike per N quiet days, 0 = never
 	ApacheBlockOnThreshold bool `json:"apache_block_on_threshold"` // true = Apache also blocks
+
+	FirewallBackend          string `json:"firewall_backend"`           // ufw (default) | iptables | nftables | ipset | command | noop
+	ReconcileIntervalMinutes int    `json:"reconcile_interval_minutes"` // 0 = startup and on demand only
+
+	DryRun         bool     `json:"dry_run"`          // observe only, never touch the firewall
+	DryRunServices []string `json:"dry_run_services"` // observe only for these services
+
+	// Subnet aggregation: once Threshold addresses of the same prefix are
+	// blocked within the window, the whole prefix is blocked instead.
+	AggregateV4Prefix      int `json:"aggregate_v4_prefix"`      // default 24
+	AggregateV4Threshold   int `json:"aggregate_v4_threshold"`   // 0 = disabled
+	AggregateV6Prefix      int `json:"aggregate_v6_prefix"`      // default 64
+	AggregateV6Threshold   int `json:"aggregate_v6_threshold"`   // 0 = disabled
+	AggregateWindowMinutes int `json:"aggregate_window_minutes"` // default 60
+
+	// Block scope: "host" denies everything from the offender, "service"
+	// only the ports of the attacked service.
+	BlockScope      string              `json:"block_scope"`       // host (default) | service
+	ServicePorts    map[string][]string `json:"service_ports"`     // e.g. {"ssh": ["2222/tcp"]}
+	FTPPassivePorts string              `json:"ftp_passive_ports"` // e.g. "40000:40100"
+
+	// Firewall action queue.
+	FirewallWorkers             int    `json:"firewall_workers"`               // default 2
+	FirewallMaxAttempts         int    `json:"firewall_max_attempts"`          // default 3
+	FirewallRetryBackoffSeconds int    `json:"firewall_retry_backoff_seconds"` // default 2, doubled per retry
+	FailedActionsFile           string `json:"failed_actions_file"`            // persisted failed actions, never replayed
+
+	CommandActions []CommandAction `json:"command_actions"` // chained by the command backend
+
+	// Audit trail of operator actions taken through the API.
+	AuditLogFile     string `json:"audit_log_file"`     // default "audit.jsonl"
+	AuditActorHeader string `json:"audit_actor_header"` // identity set by the auth proxy, default "X-Forwarded-User"
+	// Peers whose actor header is believed (addresses or CIDR ranges,
+	// default loopback only); requests from anyone else are "anonymous".
+	AuditTrustedProxies []string `json:"audit_trusted_proxies"`
+
+	// On-disk alert history served by /api/alerts.
+	AlertStoreDir      string `json:"alert_store_dir"`      // default "alerts"
+	AlertRetentionDays int    `json:"alert_retention_days"` // default 30
+	AlertRetentionMB   int    `json:"alert_retention_mb"`   // default 256
+
+	// Response per service: "alert" only records, "limit" rate-limits new
+	// connections from the offender, "block" denies it. Offenders that
+	// keep offending while limited are escalated to a block.
+	ServiceActions     map[string]string `json:"service_actions"`      // e.g. {"apache": "limit"}
+	LimitRate          string            `json:"limit_rate"`           // nftables rate, default "10/minute" (ufw: fixed 6/30s)
+	LimitBurst         int               `json:"limit_burst"`          // default 5
+	LimitMinutes       int               `json:"limit_minutes"`        // default 30
+	LimitEscalateAfter int               `json:"limit_escalate_after"` // offences while limited before a block, default 3
+}
+
+// reports whether decisions for the service must only be recorded.
+func (c Config) IsDryRun(service string) bool {
+	if c.DryRun {
+		return true
+	}
+	for _, s := range c.DryRunServices {
+		if strings.EqualFold(strings.TrimSpace(s), service) {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the path of the block database.
+func (c Config) BlockDBPath() string {
+	if c.BlockDBFile != "" {
+		return c.BlockDBFile
+	}
+	return "blocked_db.jsonl"
+}
+
+// returns the path of the saved log offsets.
+func (c Config) TailStatePath() string {
+	if c.TailStateFile != "" {
+		return c.TailStateFile
+	}
+	return "tail_state.json"
+}
+
+// returns the path of the saved journal cursors.
+func (c Config) JournalCursorPath() string {
+	if c.JournalCursorFile != "" {
+		return c.JournalCursorFile
+	}
+	return "journal_cursor.json"
+}
+
+// JournalSource feeds the parser of a service from the systemd journal.
+// Entries match any of Units or Identifiers.
+type JournalSource struct {
+	Name        string   `json:"name"`        // cursor key, default the service
+	Service     string   `json:"service"`     // ssh | ftp | apache
+	Units       []string `json:"units"`       // _SYSTEMD_UNIT values, e.g. "ssh.service"
+	Identifiers []string `json:"identifiers"` // SYSLOG_IDENTIFIER values, e.g. "sshd"
+	ExportFile  string   `json:"export_file"` // read this journal export file instead of journalctl (testing)
+}
+
+// CommandAction is a user-defined enforcement action run by the
+// "command" firewall backend. Templates accept {ip}, {service},
+// {duration}, {strikes} and {ports}, and must be safe to run twice:
+// retries repeat every action.
+type CommandAction struct {
+	Name           string `json:"name"`
+	Block          string `json:"block"`
+	Unblock        string `json:"unblock"`
+	List           string `json:"list"`            // optional, prints one target per line
+	TimeoutSeconds int    `json:"timeout_seconds"` // default 30
 }
 
 // reads configuration from the JSON file path.
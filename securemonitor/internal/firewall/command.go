@@ -0,0 +1,248 @@
+package firewall
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"securemonitor/internal/config"
+	"securemonitor/internal/ipaddr"
+)
+
+// Command enforces blocks by running user-defined command templates, in
+// the spirit of fail2ban actions: a cloud security group CLI, a router
+// script, /etc/hosts.deny, ... Several actions are chained for every
+// decision. Templates may use these placeholders:
+//
+//	{ip}        address or CIDR being blocked
+//	{service}   service that triggered the block (ssh, ftp, apache)
+//	{duration}  ban duration in seconds (0 = until unblocked)
+//	{strikes}   strike count of the block
+//	{ports}     comma separated ports of a scoped block ("" = all)
+//
+// Templates are split into arguments before substitution and run without
+// a shell, so a placeholder always stays a single argument. A template
+// that starts a shell itself must pass the values to its script as
+// arguments ("sh -c '... \"$1\" ...' name {ip}"), never inside it.
+//
+// The queue retries a failed decision by running every action again,
+// including those that had succeeded, so actions must be idempotent.
+type Command struct {
+	actions []config.CommandAction
+
+	mu      sync.Mutex
+	blocked map[string]Rule // what we applied, when no list command exists
+}
+
+// builds the command backend from the configured actions.
+func NewCommand(actions []config.CommandAction) (*Command, error) {
+	if len(actions) == 0 {
+		return nil, fmt.Errorf("firewall: command backend needs at least one command_actions entry")
+	}
+	for i, a := range actions {
+		if strings.TrimSpace(a.Block) == "" {
+			return nil, fmt.Errorf("firewall: command action %d (%s) has no block template", i, a.Name)
+		}
+		if _, err := splitArgs(a.Block); err != nil {
+			return nil, fmt.Errorf("firewall: command action %s: %w", a.Name, err)
+		}
+	}
+	return &Command{actions: actions, blocked: make(map[string]Rule)}, nil
+}
+
+func (c *Command) Name() string {
+	return "command"
+}
+
+// runs every block template in order. All actions are attempted even if
+// one fails; a retry runs them all again.
+func (c *Command) Block(r Rule) error {
+	var errs []error
+	for _, a := range c.actions {
+		if _, err := c.runTemplate(a, "block", a.Block, r); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.blocked[r.IP] = Rule{IP: r.IP, Ports: r.Ports}
+	c.mu.Unlock()
+	return nil
+}
+
+// runs every unblock template in reverse order, undoing the chain.
+func (c *Command) Unblock(r Rule) error {
+	var errs []error
+	for i := len(c.actions) - 1; i >= 0; i-- {
+		a := c.actions[i]
+		if strings.TrimSpace(a.Unblock) == "" {
+			continue
+		}
+		if _, err := c.runTemplate(a, "unblock", a.Unblock, r); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	delete(c.blocked, r.IP)
+	c.mu.Unlock()
+	return nil
+}
+
+// returns the targets printed (one per line) by the first action with a
+// list template, or what this process applied when there is none.
+func (c *Command) List() ([]Rule, error) {
+	for _, a := range c.actions {
+		if strings.TrimSpace(a.List) == "" {
+			continue
+		}
+
+		out, err := c.runTemplate(a, "list", a.List, Rule{})
+		if err != nil {
+			return nil, err
+		}
+
+		var rules []Rule
+		for _, line := range strings.Split(out, "\n") {
+			if target := ipaddr.CanonicalTarget(line); target != "" {
+				rules = append(rules, Rule{IP: target})
+			}
+		}
+		return mergeRules(rules), nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	rules := make([]Rule, 0, len(c.blocked))
+	for _, r := range c.blocked {
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// verifies every program referenced by the templates can be found.
+func (c *Command) Check() error {
+	for _, a := range c.actions {
+		for _, tmpl := range []string{a.Block, a.Unblock, a.List} {
+			args, err := splitArgs(tmpl)
+			if err != nil || len(args) == 0 {
+				continue
+			}
+			if _, err := exec.LookPath(args[0]); err != nil {
+				return fmt.Errorf("command action %s: %w", a.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// expands and runs one template with the action timeout, logging the
+// captured output. Returns stdout.
+func (c *Command) runTemplate(a config.CommandAction, op, tmpl string, r Rule) (string, error) {
+	args, err := splitArgs(tmpl)
+	if err != nil {
+		return "", err
+	}
+	if len(args) == 0 {
+		return "", nil
+	}
+
+	vars := strings.NewReplacer(
+		"{ip}", r.IP,
+		"{service}", r.Service,
+		"{duration}", strconv.FormatInt(int64(r.Timeout.Seconds()), 10),
+		"{strikes}", strconv.Itoa(r.Strikes),
+		"{ports}", strings.Join(r.Ports, ","),
+	)
+	for i := range args {
+		args[i] = vars.Replace(args[i])
+	}
+
+	timeout := time.Duration(a.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+
+	output := strings.TrimSpace(stdout.String() + "\n" + stderr.String())
+	if output != "" {
+		log.Printf("firewall: command %s %s %s: %s", a.Name, op, r.IP, output)
+	}
+	if err != nil {
+		return stdout.String(), fmt.Errorf("command %s %s: %w: %s", a.Name, op, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// splits a template into arguments on whitespace, honouring single and
+// double quotes and backslash escapes outside single quotes.
+func splitArgs(s string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	inArg := false
+	var quote rune
+
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		ch := runes[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			} else if ch == '\\' && quote == '"' && i+1 < len(runes) {
+				i++
+				cur.WriteRune(runes[i])
+			} else {
+				cur.WriteRune(ch)
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+			inArg = true
+		case ch == '\\' && i+1 < len(runes):
+			i++
+			cur.WriteRune(runes[i])
+			inArg = true
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(ch)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", s)
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
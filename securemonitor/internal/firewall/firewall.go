@@ -1,48 +1,432 @@
 package firewall
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"log"
-	"os"
+	"net/netip"
 	"os/exec"
+	"sort"
 	"strings"
+	"sync"
+	"time"
+
+	"securemonitor/internal/config"
 )
 
-//  adds a deny rule for the given IP using ufw.
-func BlockIP(ip string) {
-	ip = strings.TrimSpace(ip)
-	if ip == "" {
-		log.Println("firewall: empty ip, skipping block")
-		return
+// Rule describes one block decision handed to a backend.
+type Rule struct {
+	// IP is a single address or, for aggregated blocks, a CIDR prefix.
+	IP string
+
+	// Timeout is how long the block should last; zero means until Unblock.
+	// Backends that can expire rules on their own (nftables) honour it,
+	// the rest rely on the monitor's auto-unblock.
+	Timeout time.Duration
+
+	// Ports scopes the block to destination ports ("22/tcp",
+	// "40000:40100/tcp"); empty means all traffic from IP is denied.
+	Ports []string
+
+	// context of the decision, for backends that report it (command).
+	Service string
+	Strikes int
+}
+
+// Backend is an enforcement mechanism able to deny and re-allow
+// traffic from a single source address.
+type Backend interface {
+	// Name returns the identifier used in config (ufw, iptables, noop, ...).
+	Name() string
+
+	// Block denies traffic from r.IP, to r.Ports only when given.
+	Block(r Rule) error
+
+	// Unblock removes a deny previously installed by Block with the
+	// same IP and Ports.
+	Unblock(r Rule) error
+
+	// List returns the rules currently installed by this backend, one
+	// per target with all of its ports merged.
+	List() ([]Rule, error)
+
+	// Check reports whether the backend is usable on this host.
+	Check() error
+}
+
+// Limiter is implemented by backends able to rate-limit new connections
+// from a source instead of denying them. Limits are kept apart from
+// blocks: List never reports them.
+type Limiter interface {
+	// Limit throttles new connections from r.IP, to r.Ports only when given.
+	Limit(r Rule) error
+
+	// Unlimit removes a limit previously installed by Limit.
+	Unlimit(r Rule) error
+
+	// ListLimits returns the limits currently installed by Limit, one
+	// per target with all of its ports merged.
+	ListLimits() ([]Rule, error)
+}
+
+// LegacyLister is implemented by backends that may hold deny rules added
+// before rules were tagged as ours (ufw rules without comment).
+type LegacyLister interface {
+	// ListUntagged returns the deny rules that do not carry our tag.
+	ListUntagged() ([]Rule, error)
+}
+
+// Batcher is implemented by backends able to apply many changes with one
+// call. Block and Unblock still apply their change before returning;
+// ApplyBatch is how the queue applies whatever is waiting at once, and
+// its error stands for every job of the batch.
+type Batcher interface {
+	ApplyBatch(jobs []Job) error
+}
+
+var (
+	backendMu sync.RWMutex
+	active    Backend = NewUFW()
+)
+
+// builds the backend selected by cfg.FirewallBackend. An empty name
+// means ufw, which keeps the behaviour of older configs. Services set to
+// rate-limit are refused when the backend cannot do it.
+func New(cfg config.Config) (Backend, error) {
+	b, err := newBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := b.(Limiter); ok {
+		return b, nil
+	}
+
+	services := make([]string, 0, len(cfg.ServiceActions))
+	for service := range cfg.ServiceActions {
+		services = append(services, service)
 	}
+	sort.Strings(services)
+	for _, service := range services {
+		if strings.EqualFold(strings.TrimSpace(cfg.ServiceActions[service]), "limit") {
+			return nil, fmt.Errorf("firewall: backend %s cannot rate-limit, set service_actions.%s to \"block\" or \"alert\"", b.Name(), service)
+		}
+	}
+	return b, nil
+}
 
-	cmd := exec.Command("sudo", "/usr/sbin/ufw", "deny", "from", ip)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+func newBackend(cfg config.Config) (Backend, error) {
+	name := cfg.FirewallBackend
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "ufw":
+		return NewUFW(), nil
+	case "iptables":
+		return NewIPTables(), nil
+	case "nftables", "nft":
+		return NewNFTables(cfg.LimitRate, cfg.LimitBurst), nil
+	case "ipset":
+		return NewIPSet(), nil
+	case "command":
+		return NewCommand(cfg.CommandActions)
+	case "noop", "none":
+		return NewRecorder(), nil
+	default:
+		return nil, fmt.Errorf("firewall: unknown backend %q", name)
+	}
+}
 
-	if err := cmd.Run(); err != nil {
-		log.Printf("firewall: failed to block %s: %v", ip, err)
+// replaces the backend used by BlockIP and UnblockIP.
+func SetBackend(b Backend) {
+	if b == nil {
 		return
 	}
+	backendMu.Lock()
+	active = b
+	backendMu.Unlock()
+}
 
-	log.Printf("firewall: blocked %s", ip)
+// returns the backend used by BlockIP and UnblockIP.
+func Active() Backend {
+	backendMu.RLock()
+	defer backendMu.RUnlock()
+	return active
 }
 
-//  removes a deny rule for the given IP using ufw.
-func UnblockIP(ip string) {
-	ip = strings.TrimSpace(ip)
-	if ip == "" {
-		log.Println("firewall: empty ip, skipping unblock")
-		return
+// applies a block decision using the active backend.
+func Block(r Rule) error {
+	r.IP = strings.TrimSpace(r.IP)
+	if r.IP == "" {
+		return fmt.Errorf("firewall: empty ip, skipping block")
 	}
 
-	cmd := exec.Command("sudo", "/usr/sbin/ufw", "delete", "deny", "from", ip)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	b := Active()
+	if err := b.Block(r); err != nil {
+		log.Printf("firewall: %s failed to block %s: %v", b.Name(), r.IP, err)
+		return err
+	}
 
-	if err := cmd.Run(); err != nil {
-		log.Printf("firewall: failed to unblock %s: %v", ip, err)
-		return
+	log.Printf("firewall: %s blocked %s", b.Name(), describe(r))
+	return nil
+}
+
+// removes a block using the active backend.
+func Unblock(r Rule) error {
+	r.IP = strings.TrimSpace(r.IP)
+	if r.IP == "" {
+		return fmt.Errorf("firewall: empty ip, skipping unblock")
+	}
+
+	b := Active()
+	if err := b.Unblock(r); err != nil {
+		log.Printf("firewall: %s failed to unblock %s: %v", b.Name(), r.IP, err)
+		return err
+	}
+
+	log.Printf("firewall: %s unblocked %s", b.Name(), describe(r))
+	return nil
+}
+
+// rate-limits a source using the active backend, if it can.
+func Limit(r Rule) error {
+	r.IP = strings.TrimSpace(r.IP)
+	if r.IP == "" {
+		return fmt.Errorf("firewall: empty ip, skipping limit")
+	}
+
+	b := Active()
+	l, ok := b.(Limiter)
+	if !ok {
+		return fmt.Errorf("firewall: %s backend does not support rate limits", b.Name())
+	}
+	if err := l.Limit(r); err != nil {
+		log.Printf("firewall: %s failed to limit %s: %v", b.Name(), r.IP, err)
+		return err
+	}
+
+	log.Printf("firewall: %s limited %s", b.Name(), describe(r))
+	return nil
+}
+
+// removes a rate limit using the active backend.
+func Unlimit(r Rule) error {
+	r.IP = strings.TrimSpace(r.IP)
+	if r.IP == "" {
+		return fmt.Errorf("firewall: empty ip, skipping unlimit")
+	}
+
+	b := Active()
+	l, ok := b.(Limiter)
+	if !ok {
+		return fmt.Errorf("firewall: %s backend does not support rate limits", b.Name())
+	}
+	if err := l.Unlimit(r); err != nil {
+		log.Printf("firewall: %s failed to unlimit %s: %v", b.Name(), r.IP, err)
+		return err
 	}
 
-	log.Printf("firewall: unblocked %s", ip)
+	log.Printf("firewall: %s unlimited %s", b.Name(), describe(r))
+	return nil
+}
+
+// applies the jobs with one call when the active backend can batch, and
+// one by one otherwise. The error stands for every job.
+func ApplyBatch(jobs []Job) error {
+	b := Active()
+	batcher, ok := b.(Batcher)
+	if !ok {
+		for _, job := range jobs {
+			if err := apply(job); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := batcher.ApplyBatch(jobs); err != nil {
+		log.Printf("firewall: %s failed to apply %d changes: %v", b.Name(), len(jobs), err)
+		return err
+	}
+	log.Printf("firewall: %s applied %d changes", b.Name(), len(jobs))
+	return nil
+}
+
+// denies the given IP until it is explicitly unblocked.
+func BlockIP(ip string) error {
+	return Block(Rule{IP: ip})
+}
+
+// removes the deny for the given IP.
+func UnblockIP(ip string) error {
+	return Unblock(Rule{IP: ip})
+}
+
+// how long a firewall tool may run before it is killed.
+const commandTimeout = 30 * time.Second
+
+// runs a privileged command through sudo and returns its stdout.
+// On failure the error carries whatever the command printed on stderr.
+func run(name string, args ...string) (string, error) {
+	return runInput("", name, args...)
+}
+
+// same as run, feeding stdin to the command (used for nft -f -). Tests
+// replace it to fake the firewall tools.
+var runInput = runCommand
+
+// runs the command through sudo. A command still running after
+// commandTimeout is killed, so a hung firewall tool cannot hold a queue
+// worker forever.
+func runCommand(stdin string, name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sudo", append([]string{name}, args...)...)
+	// the killed sudo may leave the tool holding our pipes open.
+	cmd.WaitDelay = 5 * time.Second
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", commandTimeout)
+	}
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return stdout.String(), fmt.Errorf("%s %s: %w", name, strings.Join(args, " "), err)
+		}
+		return stdout.String(), fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, msg)
+	}
+	return stdout.String(), nil
+}
+
+// returns the addresses denied by the active backend.
+func List() ([]Rule, error) {
+	return Active().List()
+}
+
+// returns the untagged deny rules of the active backend; none when the
+// backend always tagged its rules.
+func ListUntagged() ([]Rule, error) {
+	l, ok := Active().(LegacyLister)
+	if !ok {
+		return nil, nil
+	}
+	return l.ListUntagged()
+}
+
+// returns the rate limits of the active backend, and whether it can
+// rate-limit at all.
+func ListLimits() ([]Rule, bool, error) {
+	l, ok := Active().(Limiter)
+	if !ok {
+		return nil, false, nil
+	}
+	rules, err := l.ListLimits()
+	return rules, true, err
+}
+
+// classifies a rule target as understood by the set-based backends:
+// whether it is IPv6 and whether it is a CIDR prefix rather than a host.
+func classify(target string) (v6, prefix bool, err error) {
+	if strings.Contains(target, "/") {
+		p, err := netip.ParsePrefix(target)
+		if err != nil {
+			return false, false, fmt.Errorf("invalid prefix %q", target)
+		}
+		return !p.Addr().Unmap().Is4(), true, nil
+	}
+
+	addr, err := netip.ParseAddr(target)
+	if err != nil {
+		return false, false, fmt.Errorf("invalid address %q", target)
+	}
+	return !addr.Unmap().Is4(), false, nil
+}
+
+// formats a rule for logs, e.g. "203.0.113.7 ports=22/tcp for 5m0s".
+func describe(r Rule) string {
+	out := r.IP
+	if len(r.Ports) > 0 {
+		out += " ports=" + strings.Join(r.Ports, ",")
+	}
+	if r.Timeout > 0 {
+		out += " for " + r.Timeout.String()
+	}
+	return out
+}
+
+// splits "22/tcp" or "40000:40100/udp" into port (or range) and protocol.
+// The protocol defaults to tcp.
+func splitPort(p string) (port, proto string) {
+	port, proto, ok := strings.Cut(strings.TrimSpace(p), "/")
+	if !ok || proto == "" {
+		proto = "tcp"
+	}
+	return port, strings.ToLower(proto)
+}
+
+// groups ports by protocol, keeping the order protocols first appear in.
+func portsByProto(ports []string) ([]string, map[string][]string) {
+	var protos []string
+	byProto := make(map[string][]string)
+	for _, p := range ports {
+		port, proto := splitPort(p)
+		if port == "" {
+			continue
+		}
+		if _, ok := byProto[proto]; !ok {
+			protos = append(protos, proto)
+		}
+		byProto[proto] = append(byProto[proto], port)
+	}
+	return protos, byProto
+}
+
+// folds listed rules that share a target into one rule. A target listed
+// once without ports is a host-wide block and keeps no ports.
+func mergeRules(rules []Rule) []Rule {
+	var order []string
+	merged := make(map[string]*Rule)
+	hostWide := make(map[string]bool)
+
+	for _, r := range rules {
+		m, ok := merged[r.IP]
+		if !ok {
+			m = &Rule{IP: r.IP}
+			merged[r.IP] = m
+			order = append(order, r.IP)
+		}
+		if len(r.Ports) == 0 {
+			hostWide[r.IP] = true
+		}
+		for _, p := range r.Ports {
+			if !containsString(m.Ports, p) {
+				m.Ports = append(m.Ports, p)
+			}
+		}
+	}
+
+	out := make([]Rule, 0, len(order))
+	for _, ip := range order {
+		r := *merged[ip]
+		if hostWide[ip] {
+			r.Ports = nil
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
 }
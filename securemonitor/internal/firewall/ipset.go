@@ -0,0 +1,250 @@
+package firewall
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	ipsetPath    = "/usr/sbin/ipset"
+	ipsetSet4    = "securemonitor4"
+	ipsetSet6    = "securemonitor6"
+	ipsetNetSet4 = "securemonitor4net"
+	ipsetNetSet6 = "securemonitor6net"
+	ipsetSvcSet4 = "securemonitor4svc"
+	ipsetSvcSet6 = "securemonitor6svc"
+)
+
+// our sets with the type, family and iptables binary used to match them.
+// Service-scoped blocks live in hash:net,port sets matched on src,dst.
+var ipsetSets = []struct {
+	name, kind, family, iptables, match string
+}{
+	{ipsetSet4, "hash:ip", "inet", iptablesPath, "src"},
+	{ipsetSet6, "hash:ip", "inet6", ip6tablesPath, "src"},
+	{ipsetNetSet4, "hash:net", "inet", iptablesPath, "src"},
+	{ipsetNetSet6, "hash:net", "inet6", ip6tablesPath, "src"},
+	{ipsetSvcSet4, "hash:net,port", "inet", iptablesPath, "src,dst"},
+	{ipsetSvcSet6, "hash:net,port", "inet6", ip6tablesPath, "src,dst"},
+}
+
+// IPSet enforces blocks as members of hash:ip sets (hash:net for CIDR
+// blocks, hash:net,port for service-scoped ones), each referenced by a
+// single DROP rule. Block and Unblock apply their change right away;
+// ApplyBatch applies the changes the queue collected in one "ipset
+// restore" instead of one process per address.
+type IPSet struct {
+	mu    sync.Mutex
+	ready bool
+}
+
+// builds the ipset backend.
+func NewIPSet() *IPSet {
+	return &IPSet{}
+}
+
+func (s *IPSet) Name() string {
+	return "ipset"
+}
+
+// creates our sets and the iptables/ip6tables rules that reference them.
+// "timeout 0" enables per-member timeouts with no default expiry.
+func (s *IPSet) ensureSets() error {
+	if s.ready {
+		return nil
+	}
+
+	for _, set := range ipsetSets {
+		if _, err := run(ipsetPath, "create", set.name, set.kind, "family", set.family, "timeout", "0", "-exist"); err != nil {
+			return err
+		}
+
+		match := []string{"INPUT", "-m", "set", "--match-set", set.name, set.match, "-j", "DROP"}
+		if _, err := run(set.iptables, append([]string{"-C"}, match...)...); err != nil {
+			if _, err := run(set.iptables, append([]string{"-I"}, match...)...); err != nil {
+				return err
+			}
+		}
+	}
+
+	s.ready = true
+	return nil
+}
+
+// returns the set that holds r and its members there, e.g.
+// "203.0.113.7" or "203.0.113.7,tcp:40000-40100".
+func ipsetMembers(r Rule) (string, []string, error) {
+	v6, prefix, err := classify(r.IP)
+	if err != nil {
+		return "", nil, fmt.Errorf("ipset: %w", err)
+	}
+
+	if len(r.Ports) > 0 {
+		set := ipsetSvcSet4
+		if v6 {
+			set = ipsetSvcSet6
+		}
+		members := make([]string, 0, len(r.Ports))
+		for _, p := range r.Ports {
+			port, proto := splitPort(p)
+			members = append(members, r.IP+","+proto+":"+strings.Replace(port, ":", "-", 1))
+		}
+		return set, members, nil
+	}
+
+	switch {
+	case prefix && v6:
+		return ipsetNetSet6, []string{r.IP}, nil
+	case prefix:
+		return ipsetNetSet4, []string{r.IP}, nil
+	case v6:
+		return ipsetSet6, []string{r.IP}, nil
+	default:
+		return ipsetSet4, []string{r.IP}, nil
+	}
+}
+
+// returns the "ipset restore" lines that add r, with the rule timeout
+// when one is given.
+func ipsetAddLines(r Rule) ([]string, error) {
+	set, members, err := ipsetMembers(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var timeout string
+	if secs := int64(r.Timeout.Seconds()); secs > 0 {
+		timeout = fmt.Sprintf(" timeout %d", secs)
+	}
+
+	lines := make([]string, 0, len(members))
+	for _, m := range members {
+		lines = append(lines, "add "+set+" "+m+timeout)
+	}
+	return lines, nil
+}
+
+// returns the "ipset restore" lines that delete r.
+func ipsetDelLines(r Rule) ([]string, error) {
+	set, members, err := ipsetMembers(r)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]string, 0, len(members))
+	for _, m := range members {
+		lines = append(lines, "del "+set+" "+m)
+	}
+	return lines, nil
+}
+
+// adds the members for r.
+func (s *IPSet) Block(r Rule) error {
+	lines, err := ipsetAddLines(r)
+	if err != nil {
+		return err
+	}
+	return s.restore(lines)
+}
+
+// deletes the members for r.
+func (s *IPSet) Unblock(r Rule) error {
+	lines, err := ipsetDelLines(r)
+	if err != nil {
+		return err
+	}
+	return s.restore(lines)
+}
+
+// applies the blocks and unblocks of jobs in one restore. When it fails
+// the error stands for every job: restore stops at the first bad line,
+// so some of them may have gone through, which -exist makes harmless to
+// apply again.
+func (s *IPSet) ApplyBatch(jobs []Job) error {
+	var lines []string
+	for _, job := range jobs {
+		var more []string
+		var err error
+		switch job.Op {
+		case "block":
+			more, err = ipsetAddLines(job.Rule)
+		case "unblock":
+			more, err = ipsetDelLines(job.Rule)
+		default:
+			err = fmt.Errorf("ipset: %s is not supported", job.Op)
+		}
+		if err != nil {
+			return err
+		}
+		lines = append(lines, more...)
+	}
+	return s.restore(lines)
+}
+
+// runs the lines through one "ipset restore". -exist makes adding a
+// present member or deleting a missing one a no-op instead of an error.
+func (s *IPSet) restore(lines []string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.ensureSets(); err != nil {
+		return err
+	}
+	script := strings.Join(lines, "\n") + "\n"
+	if _, err := runInput(script, ipsetPath, "restore", "-exist"); err != nil {
+		return fmt.Errorf("ipset: restore of %d changes failed: %w", len(lines), err)
+	}
+	return nil
+}
+
+// parses "ipset save" for all our sets, e.g.
+//
+//	add securemonitor4 203.0.113.7 timeout 287
+//	add securemonitor4svc 203.0.113.8,tcp:22 timeout 287
+//
+// A port range comes back as one member, and so one port, per port.
+func (s *IPSet) List() ([]Rule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.ensureSets(); err != nil {
+		return nil, err
+	}
+
+	var rules []Rule
+	for _, set := range ipsetSets {
+		out, err := run(ipsetPath, "save", set.name)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(out, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 3 || fields[0] != "add" {
+				continue
+			}
+
+			ip, svc, scoped := strings.Cut(fields[2], ",")
+			r := Rule{IP: ip}
+			if scoped {
+				proto, port, _ := strings.Cut(svc, ":")
+				r.Ports = []string{strings.Replace(port, "-", ":", 1) + "/" + proto}
+			}
+			rules = append(rules, r)
+		}
+	}
+	return mergeRules(rules), nil
+}
+
+// verifies ipset/iptables work and the sets and rules are in place.
+func (s *IPSet) Check() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ready = false
+	return s.ensureSets()
+}
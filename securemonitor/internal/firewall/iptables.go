@@ -0,0 +1,226 @@
+package firewall
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	iptablesPath  = "/usr/sbin/iptables"
+	ip6tablesPath = "/usr/sbin/ip6tables"
+	iptablesChain = "SECUREMONITOR"
+)
+
+// IPTables enforces blocks with DROP rules in a dedicated chain that is
+// jumped to from INPUT, so our rules never mix with the host's own.
+// IPv6 targets go through ip6tables with an identical chain.
+type IPTables struct{}
+
+// builds the iptables backend.
+func NewIPTables() *IPTables {
+	return &IPTables{}
+}
+
+func (t *IPTables) Name() string {
+	return "iptables"
+}
+
+// returns the binary handling the family of the target.
+func iptablesFor(target string) (string, error) {
+	v6, _, err := classify(target)
+	if err != nil {
+		return "", fmt.Errorf("iptables: %w", err)
+	}
+	if v6 {
+		return ip6tablesPath, nil
+	}
+	return iptablesPath, nil
+}
+
+// creates the chain and the INPUT jump if they do not exist yet.
+func (t *IPTables) ensureChain(bin string) error {
+	if _, err := run(bin, "-n", "-L", iptablesChain); err != nil {
+		if _, err := run(bin, "-N", iptablesChain); err != nil {
+			return err
+		}
+	}
+	if _, err := run(bin, "-C", "INPUT", "-j", iptablesChain); err != nil {
+		if _, err := run(bin, "-I", "INPUT", "-j", iptablesChain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returns the rule specs needed to enforce r: one host-wide DROP, or one
+// multiport DROP per protocol for scoped blocks.
+func iptablesSpecs(r Rule) [][]string {
+	if len(r.Ports) == 0 {
+		return [][]string{{"-s", r.IP, "-j", "DROP"}}
+	}
+
+	protos, byProto := portsByProto(r.Ports)
+	specs := make([][]string, 0, len(protos))
+	for _, proto := range protos {
+		specs = append(specs, []string{
+			"-s", r.IP, "-p", proto,
+			"-m", "multiport", "--dports", strings.Join(byProto[proto], ","),
+			"-j", "DROP",
+		})
+	}
+	return specs
+}
+
+// appends the DROP rules for the given IP.
+func (t *IPTables) Block(r Rule) error {
+	bin, err := iptablesFor(r.IP)
+	if err != nil {
+		return err
+	}
+	if err := t.ensureChain(bin); err != nil {
+		return err
+	}
+	for _, spec := range iptablesSpecs(r) {
+		// already present: nothing to do.
+		if _, err := run(bin, append([]string{"-C", iptablesChain}, spec...)...); err == nil {
+			continue
+		}
+		if _, err := run(bin, append([]string{"-A", iptablesChain}, spec...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deletes the DROP rules for the given IP: every rule of the IP when r
+// has no ports, otherwise the given ports. Live rules are deleted as
+// listed, since a widened block is several multiport rules that no spec
+// built from r would match; ports of a deleted rule that r does not lift
+// are put back.
+func (t *IPTables) Unblock(r Rule) error {
+	bin, err := iptablesFor(r.IP)
+	if err != nil {
+		return err
+	}
+	out, err := run(bin, "-S", iptablesChain)
+	if err != nil {
+		return err
+	}
+
+	lift := canonicalPorts(r.Ports)
+	for _, line := range strings.Split(out, "\n") {
+		live, ok := parseIPTablesRule(line)
+		if !ok || live.IP != trimHostPrefix(r.IP) {
+			continue
+		}
+
+		var keep []string
+		if len(lift) > 0 {
+			// lifting ports leaves a host-wide rule alone.
+			if len(live.Ports) == 0 {
+				continue
+			}
+			for _, p := range live.Ports {
+				if !containsString(lift, p) {
+					keep = append(keep, p)
+				}
+			}
+			if len(keep) == len(live.Ports) {
+				continue
+			}
+		}
+
+		fields := strings.Fields(line)
+		if _, err := run(bin, append([]string{"-D"}, fields[1:]...)...); err != nil {
+			return err
+		}
+		if len(keep) > 0 {
+			if err := t.Block(Rule{IP: r.IP, Ports: keep}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// returns ports as "port/proto", the form parseIPTablesRule lists them in.
+func canonicalPorts(ports []string) []string {
+	out := make([]string, 0, len(ports))
+	for _, p := range ports {
+		if port, proto := splitPort(p); port != "" {
+			out = append(out, port+"/"+proto)
+		}
+	}
+	return out
+}
+
+// drops the host prefix length iptables prints after single addresses.
+func trimHostPrefix(ip string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(ip, "/32"), "/128")
+}
+
+// parses "iptables -S" / "ip6tables -S" for our chain, e.g.
+//
+//	-A SECUREMONITOR -s 203.0.113.7/32 -j DROP
+//	-A SECUREMONITOR -s 203.0.113.8/32 -p tcp -m multiport --dports 21,40000:40100 -j DROP
+//	-A SECUREMONITOR -s 2001:db8::7/128 -j DROP
+func (t *IPTables) List() ([]Rule, error) {
+	var rules []Rule
+	for _, bin := range []string{iptablesPath, ip6tablesPath} {
+		if err := t.ensureChain(bin); err != nil {
+			return nil, err
+		}
+		out, err := run(bin, "-S", iptablesChain)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, line := range strings.Split(out, "\n") {
+			if r, ok := parseIPTablesRule(line); ok {
+				rules = append(rules, r)
+			}
+		}
+	}
+	return mergeRules(rules), nil
+}
+
+// converts one "-A" line of our chain back into a rule.
+func parseIPTablesRule(line string) (Rule, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || fields[0] != "-A" || fields[1] != iptablesChain {
+		return Rule{}, false
+	}
+
+	var r Rule
+	proto := "tcp"
+	var dports string
+	for i := 0; i+1 < len(fields); i++ {
+		switch fields[i] {
+		case "-s":
+			r.IP = trimHostPrefix(fields[i+1])
+		case "-p":
+			proto = fields[i+1]
+		case "--dports", "--dport":
+			dports = fields[i+1]
+		}
+	}
+	if r.IP == "" {
+		return r, false
+	}
+	if dports != "" {
+		for _, port := range strings.Split(dports, ",") {
+			r.Ports = append(r.Ports, port+"/"+proto)
+		}
+	}
+	return r, true
+}
+
+// verifies iptables and ip6tables can be invoked and the chains set up.
+func (t *IPTables) Check() error {
+	for _, bin := range []string{iptablesPath, ip6tablesPath} {
+		if err := t.ensureChain(bin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
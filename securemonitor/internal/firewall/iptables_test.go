@@ -0,0 +1,149 @@
+package firewall
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeIPTables keeps the rules of our chain and answers the iptables
+// calls the backend makes, printing addresses with their host prefix
+// length as iptables -S does.
+type fakeIPTables struct {
+	rules [][]string // specs, in chain order
+}
+
+func (f *fakeIPTables) run(stdin, name string, args ...string) (string, error) {
+	if len(args) < 2 {
+		return "", errors.New("unexpected call")
+	}
+	op, chain, spec := args[0], args[1], args[2:]
+	if chain != iptablesChain {
+		return "", nil // chain setup and the INPUT jump
+	}
+
+	i := slices.IndexFunc(f.rules, func(r []string) bool { return slices.Equal(r, f.normalize(spec)) })
+	switch op {
+	case "-n", "-N":
+		return "", nil
+	case "-C":
+		if i < 0 {
+			return "", errors.New("no such rule")
+		}
+	case "-A":
+		f.rules = append(f.rules, f.normalize(spec))
+	case "-D":
+		if i < 0 {
+			return "", errors.New("no such rule")
+		}
+		f.rules = slices.Delete(f.rules, i, i+1)
+	case "-S":
+		var b strings.Builder
+		b.WriteString("-N " + iptablesChain + "\n")
+		for _, r := range f.rules {
+			b.WriteString("-A " + iptablesChain + " " + strings.Join(r, " ") + "\n")
+		}
+		return b.String(), nil
+	}
+	return "", nil
+}
+
+// adds the host prefix length to the source address.
+func (f *fakeIPTables) normalize(spec []string) []string {
+	out := slices.Clone(spec)
+	for i := 0; i+1 < len(out); i++ {
+		if out[i] == "-s" && !strings.Contains(out[i+1], "/") {
+			out[i+1] += "/32"
+		}
+	}
+	return out
+}
+
+// returns the ports left in the chain for ip, "host" for a host-wide
+// rule.
+func (f *fakeIPTables) ports(ip string) []string {
+	var out []string
+	for _, spec := range f.rules {
+		r, ok := parseIPTablesRule("-A " + iptablesChain + " " + strings.Join(spec, " "))
+		if !ok || r.IP != ip {
+			continue
+		}
+		if len(r.Ports) == 0 {
+			out = append(out, "host")
+		}
+		out = append(out, r.Ports...)
+	}
+	slices.Sort(out)
+	return out
+}
+
+func TestIPTablesUnblock(t *testing.T) {
+	const ip = "203.0.113.8"
+
+	tests := []struct {
+		name   string
+		blocks [][]string // ports of each Block call, nil = host-wide
+		lift   []string   // ports of the Unblock call, nil = everything
+		want   []string
+	}{
+		{
+			name:   "widened block lifted whole",
+			blocks: [][]string{{"22/tcp"}, {"21/tcp", "40000:40100/tcp"}},
+			lift:   []string{"22/tcp", "21/tcp", "40000:40100/tcp"},
+		},
+		{
+			name:   "widened block lifted without ports",
+			blocks: [][]string{{"22/tcp"}, {"21/tcp"}, {"53/udp"}},
+		},
+		{
+			name:   "some ports of one rule",
+			blocks: [][]string{{"22/tcp", "21/tcp", "40000:40100/tcp"}},
+			lift:   []string{"21"},
+			want:   []string{"22/tcp", "40000:40100/tcp"},
+		},
+		{
+			name:   "ports across widened rules",
+			blocks: [][]string{{"22/tcp"}, {"21/tcp", "40000:40100/tcp"}},
+			lift:   []string{"22/tcp", "40000:40100/tcp"},
+			want:   []string{"21/tcp"},
+		},
+		{
+			name:   "scoped rules superseded by a host-wide block",
+			blocks: [][]string{{"22/tcp"}, nil},
+			lift:   []string{"22/tcp"},
+			want:   []string{"host"},
+		},
+		{
+			name: "nothing in place",
+			lift: []string{"22/tcp"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeIPTables{}
+			prev := runInput
+			runInput = fake.run
+			t.Cleanup(func() { runInput = prev })
+
+			fw := NewIPTables()
+			for _, ports := range tt.blocks {
+				if err := fw.Block(Rule{IP: ip, Ports: ports}); err != nil {
+					t.Fatalf("block %v: %v", ports, err)
+				}
+			}
+			fake.rules = append(fake.rules, []string{"-s", "198.51.100.1/32", "-j", "DROP"})
+
+			if err := fw.Unblock(Rule{IP: ip, Ports: tt.lift}); err != nil {
+				t.Fatalf("unblock: %v", err)
+			}
+			if got := fake.ports(ip); !slices.Equal(got, tt.want) {
+				t.Errorf("left in place = %v, want %v", got, tt.want)
+			}
+			if got := fake.ports("198.51.100.1"); !slices.Equal(got, []string{"host"}) {
+				t.Errorf("other address = %v, want it untouched", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,334 @@
+package firewall
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	nftPath    = "/usr/sbin/nft"
+	nftTable   = "securemonitor"
+	nftSet4    = "blocked4"
+	nftSet6    = "blocked6"
+	nftNetSet4 = "blocked4net"
+	nftNetSet6 = "blocked6net"
+	nftSvcSet4 = "blocked4svc"
+	nftSvcSet6 = "blocked6svc"
+
+	// rate-limited sources; the interval sets hold hosts and prefixes.
+	nftLimitSet4    = "limited4"
+	nftLimitSet6    = "limited6"
+	nftLimitSvcSet4 = "limited4svc"
+	nftLimitSvcSet6 = "limited6svc"
+)
+
+// ruleset loaded by ensureTable. Declaring the table and sets is additive
+// in nft, so re-running it keeps existing elements; the chain is flushed
+// first so the drop rules are never duplicated. CIDR blocks live in
+// separate interval sets, service-scoped blocks in address . protocol .
+// port sets. New connections from limited sources go through per-source
+// meters and are dropped over the rate (%[1]s: rate, %[2]d: burst).
+const nftRuleset = `table inet securemonitor {
+	set blocked4 { type ipv4_addr; flags timeout; }
+	set blocked6 { type ipv6_addr; flags timeout; }
+	set blocked4net { type ipv4_addr; flags interval, timeout; }
+	set blocked6net { type ipv6_addr; flags interval, timeout; }
+	set blocked4svc { type ipv4_addr . inet_proto . inet_service; flags interval, timeout; }
+	set blocked6svc { type ipv6_addr . inet_proto . inet_service; flags interval, timeout; }
+	set limited4 { type ipv4_addr; flags interval, timeout; }
+	set limited6 { type ipv6_addr; flags interval, timeout; }
+	set limited4svc { type ipv4_addr . inet_proto . inet_service; flags interval, timeout; }
+	set limited6svc { type ipv6_addr . inet_proto . inet_service; flags interval, timeout; }
+	set meter4 { type ipv4_addr; size 65535; flags dynamic, timeout; timeout 1m; }
+	set meter6 { type ipv6_addr; size 65535; flags dynamic, timeout; timeout 1m; }
+	chain input { type filter hook input priority filter - 10; policy accept; }
+}
+flush chain inet securemonitor input
+table inet securemonitor {
+	chain input {
+		ip saddr @blocked4 drop
+		ip6 saddr @blocked6 drop
+		ip saddr @blocked4net drop
+		ip6 saddr @blocked6net drop
+		ip saddr . meta l4proto . th dport @blocked4svc drop
+		ip6 saddr . meta l4proto . th dport @blocked6svc drop
+		ip saddr @limited4 ct state new update @meter4 { ip saddr limit rate over %[1]s burst %[2]d packets } drop
+		ip6 saddr @limited6 ct state new update @meter6 { ip6 saddr limit rate over %[1]s burst %[2]d packets } drop
+		ip saddr . meta l4proto . th dport @limited4svc ct state new update @meter4 { ip saddr limit rate over %[1]s burst %[2]d packets } drop
+		ip6 saddr . meta l4proto . th dport @limited6svc ct state new update @meter6 { ip6 saddr limit rate over %[1]s burst %[2]d packets } drop
+	}
+}
+`
+
+// NFTables enforces blocks as elements of named sets in its own table.
+// Each element carries the ban duration as an nft timeout, so the kernel
+// lifts bans on time even while the daemon is not running, and a single
+// rule per set covers any number of addresses.
+type NFTables struct {
+	// queue workers call in concurrently; the table is loaded by one of
+	// them while the others wait.
+	mu    sync.Mutex
+	ready bool
+
+	limitRate  string // e.g. "10/minute"
+	limitBurst int
+}
+
+// builds the nftables backend. rate and burst apply to rate-limited
+// sources; empty/zero pick 10/minute with a burst of 5.
+func NewNFTables(rate string, burst int) *NFTables {
+	if strings.TrimSpace(rate) == "" {
+		rate = "10/minute"
+	}
+	if burst <= 0 {
+		burst = 5
+	}
+	return &NFTables{limitRate: strings.TrimSpace(rate), limitBurst: burst}
+}
+
+func (n *NFTables) Name() string {
+	return "nftables"
+}
+
+// loads the table, sets and chain once per process.
+func (n *NFTables) ensureTable() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.ready {
+		return nil
+	}
+	ruleset := fmt.Sprintf(nftRuleset, n.limitRate, n.limitBurst)
+	if _, err := runInput(ruleset, nftPath, "-f", "-"); err != nil {
+		return err
+	}
+	n.ready = true
+	return nil
+}
+
+// returns the set that holds r and the elements (without timeout) that
+// represent it there. limit selects the rate-limit sets.
+func nftElements(r Rule, limit bool) (string, []string, error) {
+	v6, prefix, err := classify(r.IP)
+	if err != nil {
+		return "", nil, fmt.Errorf("nftables: %w", err)
+	}
+
+	if len(r.Ports) > 0 {
+		set := nftSvcSet4
+		switch {
+		case limit && v6:
+			set = nftLimitSvcSet6
+		case limit:
+			set = nftLimitSvcSet4
+		case v6:
+			set = nftSvcSet6
+		}
+		elems := make([]string, 0, len(r.Ports))
+		for _, p := range r.Ports {
+			port, proto := splitPort(p)
+			// nft writes port ranges as 40000-40100.
+			elems = append(elems, r.IP+" . "+proto+" . "+strings.Replace(port, ":", "-", 1))
+		}
+		return set, elems, nil
+	}
+
+	switch {
+	case limit && v6:
+		return nftLimitSet6, []string{r.IP}, nil
+	case limit:
+		return nftLimitSet4, []string{r.IP}, nil
+	case prefix && v6:
+		return nftNetSet6, []string{r.IP}, nil
+	case prefix:
+		return nftNetSet4, []string{r.IP}, nil
+	case v6:
+		return nftSet6, []string{r.IP}, nil
+	default:
+		return nftSet4, []string{r.IP}, nil
+	}
+}
+
+// adds the rule's elements to their set, with the rule timeout when one
+// is given.
+func (n *NFTables) Block(r Rule) error {
+	return n.addElements(r, false)
+}
+
+// deletes the rule's elements from their set. Elements the kernel
+// already expired are not an error.
+func (n *NFTables) Unblock(r Rule) error {
+	return n.deleteElements(r, false)
+}
+
+// adds the source to the rate-limit sets, with the rule timeout.
+func (n *NFTables) Limit(r Rule) error {
+	return n.addElements(r, true)
+}
+
+// removes the source from the rate-limit sets.
+func (n *NFTables) Unlimit(r Rule) error {
+	return n.deleteElements(r, true)
+}
+
+func (n *NFTables) addElements(r Rule, limit bool) error {
+	if err := n.ensureTable(); err != nil {
+		return err
+	}
+	set, elems, err := nftElements(r, limit)
+	if err != nil {
+		return err
+	}
+
+	if secs := int64(r.Timeout.Seconds()); secs > 0 {
+		for i := range elems {
+			elems[i] += fmt.Sprintf(" timeout %ds", secs)
+		}
+	}
+
+	_, err = run(nftPath, "add", "element", "inet", nftTable, set, "{ "+strings.Join(elems, ", ")+" }")
+	return err
+}
+
+func (n *NFTables) deleteElements(r Rule, limit bool) error {
+	if err := n.ensureTable(); err != nil {
+		return err
+	}
+	set, elems, err := nftElements(r, limit)
+	if err != nil {
+		return err
+	}
+
+	for _, elem := range elems {
+		_, err := run(nftPath, "delete", "element", "inet", nftTable, set, "{ "+elem+" }")
+		if err != nil && !strings.Contains(err.Error(), "No such file or directory") {
+			return err
+		}
+	}
+	return nil
+}
+
+// models the subset of "nft -j list set" output we need. Elements are
+// either plain strings or objects when they carry a timeout.
+type nftListOutput struct {
+	Nftables []struct {
+		Set *struct {
+			Elem []json.RawMessage `json:"elem"`
+		} `json:"set"`
+	} `json:"nftables"`
+}
+
+// returns every rule currently present in our block sets.
+func (n *NFTables) List() ([]Rule, error) {
+	return n.listSets(nftSet4, nftSet6, nftNetSet4, nftNetSet6, nftSvcSet4, nftSvcSet6)
+}
+
+// returns every source currently present in the rate-limit sets.
+func (n *NFTables) ListLimits() ([]Rule, error) {
+	return n.listSets(nftLimitSet4, nftLimitSet6, nftLimitSvcSet4, nftLimitSvcSet6)
+}
+
+func (n *NFTables) listSets(sets ...string) ([]Rule, error) {
+	if err := n.ensureTable(); err != nil {
+		return nil, err
+	}
+
+	var rules []Rule
+	for _, set := range sets {
+		out, err := run(nftPath, "-j", "list", "set", "inet", nftTable, set)
+		if err != nil {
+			return nil, err
+		}
+
+		var parsed nftListOutput
+		if err := json.Unmarshal([]byte(out), &parsed); err != nil {
+			return nil, fmt.Errorf("nftables: decode %s: %w", set, err)
+		}
+
+		for _, item := range parsed.Nftables {
+			if item.Set == nil {
+				continue
+			}
+			for _, raw := range item.Set.Elem {
+				if r, ok := nftElemRule(raw); ok {
+					rules = append(rules, r)
+				}
+			}
+		}
+	}
+	return mergeRules(rules), nil
+}
+
+// converts one set element back into a rule. Elements look like
+// "1.2.3.4", {"prefix": {"addr": "10.0.0.0", "len": 24}},
+// {"concat": ["1.2.3.4", "tcp", 22]} (ports may be {"range": [a, b]}),
+// or any of them wrapped as {"elem": {"val": ..., "timeout": 300, ...}}.
+func nftElemRule(raw json.RawMessage) (Rule, bool) {
+	var obj struct {
+		Concat []json.RawMessage `json:"concat"`
+		Elem   *struct {
+			Val json.RawMessage `json:"val"`
+		} `json:"elem"`
+	}
+	if err := json.Unmarshal(raw, &obj); err == nil {
+		if obj.Elem != nil {
+			return nftElemRule(obj.Elem.Val)
+		}
+		if len(obj.Concat) == 3 {
+			ip := nftElemValue(obj.Concat[0])
+			proto := nftElemValue(obj.Concat[1])
+			port := nftElemValue(obj.Concat[2])
+			if ip == "" || port == "" {
+				return Rule{}, false
+			}
+			return Rule{IP: ip, Ports: []string{port + "/" + proto}}, true
+		}
+	}
+
+	if ip := nftElemValue(raw); ip != "" {
+		return Rule{IP: ip}, true
+	}
+	return Rule{}, false
+}
+
+// extracts a scalar from an element component: strings, numbers,
+// prefixes (as CIDR) and ranges (as "a:b", the notation Rule.Ports uses).
+func nftElemValue(raw json.RawMessage) string {
+	var plain string
+	if err := json.Unmarshal(raw, &plain); err == nil {
+		return plain
+	}
+
+	var num json.Number
+	if err := json.Unmarshal(raw, &num); err == nil {
+		return num.String()
+	}
+
+	var obj struct {
+		Prefix *struct {
+			Addr string `json:"addr"`
+			Len  int    `json:"len"`
+		} `json:"prefix"`
+		Range []json.RawMessage `json:"range"`
+	}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return ""
+	}
+	if obj.Prefix != nil {
+		return fmt.Sprintf("%s/%d", obj.Prefix.Addr, obj.Prefix.Len)
+	}
+	if len(obj.Range) == 2 {
+		return nftElemValue(obj.Range[0]) + ":" + nftElemValue(obj.Range[1])
+	}
+	return ""
+}
+
+// verifies nft works and our table can be loaded.
+func (n *NFTables) Check() error {
+	n.mu.Lock()
+	n.ready = false
+	n.mu.Unlock()
+	return n.ensureTable()
+}
@@ -0,0 +1,110 @@
+package firewall
+
+import (
+	"sync"
+	"time"
+)
+
+// Action is one call recorded by the Recorder backend.
+type Action struct {
+	Op      string        `json:"op"` // "block", "unblock", "limit" or "unlimit"
+	IP      string        `json:"ip"`
+	Ports   []string      `json:"ports,omitempty"`
+	Timeout time.Duration `json:"timeout,omitempty"`
+	Time    time.Time     `json:"time"`
+}
+
+// Recorder never touches the host firewall. It remembers what would have
+// been blocked, which makes it usable on hosts without ufw/iptables and
+// for exercising the monitor without side effects.
+type Recorder struct {
+	mu      sync.Mutex
+	blocked map[string]Rule
+	limited map[string]Rule
+	actions []Action
+}
+
+// builds the no-op backend.
+func NewRecorder() *Recorder {
+	return &Recorder{blocked: make(map[string]Rule), limited: make(map[string]Rule)}
+}
+
+func (r *Recorder) Name() string {
+	return "noop"
+}
+
+func (r *Recorder) Block(rule Rule) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	merged := Rule{IP: rule.IP, Ports: rule.Ports}
+	if prev, ok := r.blocked[rule.IP]; ok {
+		merged = mergeRules([]Rule{prev, merged})[0]
+	}
+	r.blocked[rule.IP] = merged
+	r.actions = append(r.actions, Action{Op: "block", IP: rule.IP, Ports: rule.Ports, Timeout: rule.Timeout, Time: time.Now()})
+	return nil
+}
+
+func (r *Recorder) Unblock(rule Rule) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.blocked, rule.IP)
+	r.actions = append(r.actions, Action{Op: "unblock", IP: rule.IP, Ports: rule.Ports, Time: time.Now()})
+	return nil
+}
+
+func (r *Recorder) Limit(rule Rule) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.limited[rule.IP] = Rule{IP: rule.IP, Ports: rule.Ports}
+	r.actions = append(r.actions, Action{Op: "limit", IP: rule.IP, Ports: rule.Ports, Timeout: rule.Timeout, Time: time.Now()})
+	return nil
+}
+
+func (r *Recorder) Unlimit(rule Rule) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.limited, rule.IP)
+	r.actions = append(r.actions, Action{Op: "unlimit", IP: rule.IP, Ports: rule.Ports, Time: time.Now()})
+	return nil
+}
+
+func (r *Recorder) List() ([]Rule, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rules := make([]Rule, 0, len(r.blocked))
+	for _, rule := range r.blocked {
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (r *Recorder) ListLimits() ([]Rule, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rules := make([]Rule, 0, len(r.limited))
+	for _, rule := range r.limited {
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (r *Recorder) Check() error {
+	return nil
+}
+
+// returns a copy of every action recorded so far.
+func (r *Recorder) Actions() []Action {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := make([]Action, len(r.actions))
+	copy(out, r.actions)
+	return out
+}
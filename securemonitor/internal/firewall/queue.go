@@ -0,0 +1,308 @@
+package firewall
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maximum number of jobs a batching backend applies in one call, and of
+// failed jobs kept on disk.
+const (
+	maxBatch       = 256
+	maxFailedJobs  = 500
+	defaultWorkers = 2
+)
+
+// Job is one firewall change travelling through the Queue. Jobs that run
+// out of attempts are kept, and persisted, for operators to inspect; they
+// are never replayed. The reconciler repairs the firewall from the stored
+// state instead, since replaying an old job could undo a newer one.
+type Job struct {
+	Op        string    `json:"op"` // "block", "unblock", "limit" or "unlimit"
+	Rule      Rule      `json:"rule"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error,omitempty"`
+	FailedAt  time.Time `json:"failed_at,omitempty"`
+}
+
+// QueueOptions tunes a Queue; zero values fall back to sane defaults.
+type QueueOptions struct {
+	Workers     int           // concurrent firewall calls (default 2)
+	MaxAttempts int           // tries per job before it is recorded as failed (default 3)
+	Backoff     time.Duration // delay before the first retry, doubled each time (default 2s)
+	FailedFile  string        // where failed jobs are persisted ("" = memory only)
+}
+
+// Queue decouples block decisions from the firewall: jobs are applied by
+// a bounded set of workers with retries and backoff, so a slow or hung
+// backend never stalls the scan loop. Jobs for the same target always go
+// to the same worker, which keeps block/unblock of an address in order.
+type Queue struct {
+	opts     QueueOptions
+	lanes    []*lane
+	batching bool
+	pending  int64
+
+	failedMu sync.Mutex
+	failed   []Job
+}
+
+type queuedJob struct {
+	job  Job
+	done func(Job, error)
+}
+
+// lane holds the jobs waiting for one worker. It is unbounded so Submit
+// never blocks: callers hold the enforcement lock while they submit.
+type lane struct {
+	mu   sync.Mutex
+	jobs []queuedJob
+	wake chan struct{} // signalled when jobs were added
+}
+
+// appends a job and wakes the worker.
+func (l *lane) push(it queuedJob) {
+	l.mu.Lock()
+	l.jobs = append(l.jobs, it)
+	l.mu.Unlock()
+
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+// removes and returns up to max waiting jobs, oldest first.
+func (l *lane) take(max int) []queuedJob {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := min(max, len(l.jobs))
+	batch := make([]queuedJob, n)
+	copy(batch, l.jobs)
+	l.jobs = l.jobs[n:]
+	if len(l.jobs) == 0 {
+		l.jobs = nil // let the backing array go
+	}
+	return batch
+}
+
+// builds a queue for the active backend and loads the failed jobs
+// recorded by a previous run. Batching backends get a single lane, whose
+// worker applies whatever is waiting in one call. Jobs submitted before
+// Start wait in their lane.
+func NewQueue(opts QueueOptions) *Queue {
+	if opts.Workers <= 0 {
+		opts.Workers = defaultWorkers
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 3
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 2 * time.Second
+	}
+
+	q := &Queue{opts: opts}
+	workers := opts.Workers
+	if _, ok := Active().(Batcher); ok {
+		q.batching = true
+		workers = 1
+	}
+	q.lanes = make([]*lane, workers)
+	for i := range q.lanes {
+		q.lanes[i] = &lane{wake: make(chan struct{}, 1)}
+	}
+
+	q.loadFailed()
+	return q
+}
+
+// spawns one worker per lane.
+func (q *Queue) Start() {
+	for _, l := range q.lanes {
+		go q.work(l)
+	}
+	log.Printf("firewall: action queue started (workers=%d, attempts=%d)", len(q.lanes), q.opts.MaxAttempts)
+}
+
+// enqueues a block or unblock. done, if not nil, runs on the worker once
+// the job succeeded or ran out of attempts.
+func (q *Queue) Submit(op string, r Rule, done func(Job, error)) {
+	atomic.AddInt64(&q.pending, 1)
+
+	h := fnv.New32a()
+	h.Write([]byte(r.IP))
+	l := q.lanes[int(h.Sum32()%uint32(len(q.lanes)))]
+
+	l.push(queuedJob{job: Job{Op: op, Rule: r}, done: done})
+}
+
+// returns how many jobs are queued or running.
+func (q *Queue) Pending() int {
+	return int(atomic.LoadInt64(&q.pending))
+}
+
+// returns the jobs that ran out of attempts, oldest first.
+func (q *Queue) Failed() []Job {
+	q.failedMu.Lock()
+	defer q.failedMu.Unlock()
+
+	out := make([]Job, len(q.failed))
+	copy(out, q.failed)
+	return out
+}
+
+func (q *Queue) work(l *lane) {
+	size := 1
+	if q.batching {
+		size = maxBatch
+	}
+
+	for range l.wake {
+		for {
+			batch := l.take(size)
+			if len(batch) == 0 {
+				break
+			}
+			if len(batch) > 1 && q.applyBatch(batch) {
+				continue
+			}
+			for _, it := range batch {
+				q.process(it)
+			}
+		}
+	}
+}
+
+// applies a whole batch with a single call. Returns false when it
+// failed: the error is recorded on every job, which is then retried on
+// its own.
+func (q *Queue) applyBatch(batch []queuedJob) bool {
+	jobs := make([]Job, len(batch))
+	for i, it := range batch {
+		jobs[i] = it.job
+	}
+	if err := ApplyBatch(jobs); err != nil {
+		for i := range batch {
+			batch[i].job.LastError = err.Error()
+		}
+		return false
+	}
+
+	for _, it := range batch {
+		it.job.Attempts = 1
+		q.finish(it, nil)
+	}
+	return true
+}
+
+// runs one job with retries and exponential backoff.
+func (q *Queue) process(it queuedJob) {
+	var err error
+	delay := q.opts.Backoff
+
+	for attempt := 1; attempt <= q.opts.MaxAttempts; attempt++ {
+		it.job.Attempts = attempt
+		if err = apply(it.job); err == nil {
+			break
+		}
+
+		it.job.LastError = err.Error()
+		if attempt < q.opts.MaxAttempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+
+	if err != nil {
+		it.job.FailedAt = time.Now()
+		q.recordFailed(it.job)
+	}
+	q.finish(it, err)
+}
+
+// performs the job's operation on the active backend.
+func apply(job Job) error {
+	switch job.Op {
+	case "unblock":
+		return Unblock(job.Rule)
+	case "limit":
+		return Limit(job.Rule)
+	case "unlimit":
+		return Unlimit(job.Rule)
+	default:
+		return Block(job.Rule)
+	}
+}
+
+func (q *Queue) finish(it queuedJob, err error) {
+	atomic.AddInt64(&q.pending, -1)
+	if it.done != nil {
+		it.done(it.job, err)
+	}
+}
+
+// appends a failed job and persists the list. The record is informational
+// only, see Job.
+func (q *Queue) recordFailed(job Job) {
+	q.failedMu.Lock()
+	defer q.failedMu.Unlock()
+
+	log.Printf("firewall: %s %s failed after %d attempts: %s", job.Op, describe(job.Rule), job.Attempts, job.LastError)
+
+	q.failed = append(q.failed, job)
+	if len(q.failed) > maxFailedJobs {
+		q.failed = q.failed[len(q.failed)-maxFailedJobs:]
+	}
+	q.saveFailed()
+}
+
+// reads failed jobs persisted by a previous run, if any.
+func (q *Queue) loadFailed() {
+	if q.opts.FailedFile == "" {
+		return
+	}
+	data, err := os.ReadFile(q.opts.FailedFile)
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(data, &q.failed); err != nil {
+		log.Printf("firewall: ignoring unreadable %s: %v", q.opts.FailedFile, err)
+	}
+}
+
+// writes the failed jobs atomically (temp file + rename).
+// Callers must hold failedMu.
+func (q *Queue) saveFailed() {
+	if q.opts.FailedFile == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(q.failed, "", "  ")
+	if err != nil {
+		return
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(q.opts.FailedFile), ".failed-*")
+	if err != nil {
+		log.Printf("firewall: cannot persist failed jobs: %v", err)
+		return
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return
+	}
+	tmp.Close()
+
+	if err := os.Rename(tmp.Name(), q.opts.FailedFile); err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("firewall: cannot persist failed jobs: %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package firewall
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyBackend fails the first fails calls for every address, and blocks
+// while hold is open.
+type flakyBackend struct {
+	mu    sync.Mutex
+	fails int
+	calls map[string]int
+	hold  chan struct{}
+}
+
+func (b *flakyBackend) Name() string { return "flaky" }
+
+func (b *flakyBackend) Block(r Rule) error {
+	if b.hold != nil {
+		<-b.hold
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.calls[r.IP]++
+	if b.calls[r.IP] <= b.fails {
+		return errors.New("busy")
+	}
+	return nil
+}
+
+func (b *flakyBackend) Unblock(r Rule) error  { return b.Block(r) }
+func (b *flakyBackend) List() ([]Rule, error) { return nil, nil }
+func (b *flakyBackend) Check() error          { return nil }
+
+// swaps in b as the active backend for the duration of the test.
+func useBackend(t *testing.T, b Backend) {
+	prev := Active()
+	SetBackend(b)
+	t.Cleanup(func() { SetBackend(prev) })
+}
+
+func TestQueueRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		fails        int
+		maxAttempts  int
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"first try", 0, 3, 1, false},
+		{"after retries", 2, 3, 3, false},
+		{"out of attempts", 3, 3, 3, true},
+		{"single attempt", 1, 1, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useBackend(t, &flakyBackend{fails: tt.fails, calls: make(map[string]int)})
+
+			failedFile := filepath.Join(t.TempDir(), "failed.json")
+			q := NewQueue(QueueOptions{
+				Workers:     1,
+				MaxAttempts: tt.maxAttempts,
+				Backoff:     time.Millisecond,
+				FailedFile:  failedFile,
+			})
+			q.Start()
+
+			done := make(chan Job, 1)
+			var gotErr error
+			q.Submit("block", Rule{IP: "192.0.2.1"}, func(j Job, err error) {
+				gotErr = err
+				done <- j
+			})
+
+			var job Job
+			select {
+			case job = <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("job never finished")
+			}
+
+			if job.Attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", job.Attempts, tt.wantAttempts)
+			}
+			if (gotErr != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", gotErr, tt.wantErr)
+			}
+			if q.Pending() != 0 {
+				t.Errorf("pending = %d, want 0", q.Pending())
+			}
+
+			// failed jobs are kept and survive a restart.
+			wantFailed := 0
+			if tt.wantErr {
+				wantFailed = 1
+			}
+			for _, q := range []*Queue{q, NewQueue(QueueOptions{FailedFile: failedFile})} {
+				failed := q.Failed()
+				if len(failed) != wantFailed {
+					t.Fatalf("failed jobs = %d, want %d", len(failed), wantFailed)
+				}
+				if wantFailed > 0 && (failed[0].LastError != "busy" || failed[0].FailedAt.IsZero()) {
+					t.Errorf("failed job = %+v, want last error and time", failed[0])
+				}
+			}
+		})
+	}
+}
+
+func TestQueueSubmitDoesNotBlock(t *testing.T) {
+	b := &flakyBackend{calls: make(map[string]int), hold: make(chan struct{})}
+	useBackend(t, b)
+
+	q := NewQueue(QueueOptions{Workers: 1})
+	q.Start()
+
+	// far more jobs than the old lane buffer, with the worker stuck.
+	const jobs = 5000
+	var wg sync.WaitGroup
+	wg.Add(jobs)
+	submitted := make(chan struct{})
+	go func() {
+		for i := 0; i < jobs; i++ {
+			q.Submit("block", Rule{IP: "192.0.2.1"}, func(Job, error) { wg.Done() })
+		}
+		close(submitted)
+	}()
+
+	select {
+	case <-submitted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Submit blocked behind a stuck worker")
+	}
+	if q.Pending() != jobs {
+		t.Errorf("pending = %d, want %d", q.Pending(), jobs)
+	}
+
+	close(b.hold)
+	wg.Wait()
+	if got := b.calls["192.0.2.1"]; got != jobs {
+		t.Errorf("backend calls = %d, want %d", got, jobs)
+	}
+}
+
+func TestQueueSubmitBeforeStart(t *testing.T) {
+	useBackend(t, &flakyBackend{calls: make(map[string]int)})
+
+	q := NewQueue(QueueOptions{Workers: 2})
+	done := make(chan error, 1)
+	q.Submit("block", Rule{IP: "192.0.2.1"}, func(_ Job, err error) { done <- err })
+	if q.Pending() != 1 {
+		t.Errorf("pending = %d, want 1", q.Pending())
+	}
+
+	q.Start()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("err = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("job submitted before Start never ran")
+	}
+}
@@ -0,0 +1,180 @@
+package firewall
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	ufwPath = "/usr/sbin/ufw"
+
+	// comment attached to every rule we create, so reconciliation only
+	// ever touches our own rules and never the operator's.
+	ufwTag = "securemonitor"
+
+	// comment of rate-limit rules. List and ListLimits match their own
+	// tag and action exactly, so limits are never mistaken for blocks.
+	ufwLimitTag = "securemonitor-limit"
+)
+
+// UFW enforces blocks with "ufw deny from <ip>" rules, or one
+// "ufw deny proto <p> from <ip> to any port <n>" rule per scoped port.
+type UFW struct{}
+
+// builds the ufw backend.
+func NewUFW() *UFW {
+	return &UFW{}
+}
+
+func (u *UFW) Name() string {
+	return "ufw"
+}
+
+// returns the ufw arguments (after deny/limit/delete ...) for each rule
+// needed to enforce r.
+func ufwSpecs(r Rule) [][]string {
+	if len(r.Ports) == 0 {
+		return [][]string{{"from", r.IP}}
+	}
+
+	specs := make([][]string, 0, len(r.Ports))
+	for _, p := range r.Ports {
+		port, proto := splitPort(p)
+		specs = append(specs, []string{"proto", proto, "from", r.IP, "to", "any", "port", port})
+	}
+	return specs
+}
+
+// adds tagged deny rules for the given IP.
+func (u *UFW) Block(r Rule) error {
+	for _, spec := range ufwSpecs(r) {
+		args := append(append([]string{"deny"}, spec...), "comment", ufwTag)
+		if _, err := run(ufwPath, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removes the deny rules for the given IP.
+func (u *UFW) Unblock(r Rule) error {
+	for _, spec := range ufwSpecs(r) {
+		if _, err := run(ufwPath, append([]string{"delete", "deny"}, spec...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adds tagged "ufw limit" rules for the given IP. ufw's rate is fixed:
+// more than 6 new connections within 30 seconds are denied.
+func (u *UFW) Limit(r Rule) error {
+	for _, spec := range ufwSpecs(r) {
+		args := append(append([]string{"limit"}, spec...), "comment", ufwLimitTag)
+		if _, err := run(ufwPath, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removes the limit rules for the given IP.
+func (u *UFW) Unlimit(r Rule) error {
+	for _, spec := range ufwSpecs(r) {
+		if _, err := run(ufwPath, append([]string{"delete", "limit"}, spec...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parses "ufw status" and returns every DENY rule carrying our comment, e.g.
+//
+//	Anywhere                   DENY        203.0.113.7                # securemonitor
+//	22/tcp                     DENY        203.0.113.8                # securemonitor
+func (u *UFW) List() ([]Rule, error) {
+	out, err := run(ufwPath, "status")
+	if err != nil {
+		return nil, err
+	}
+	return parseUFWRules(out, "DENY", ufwTag), nil
+}
+
+// returns the DENY rules without any comment, the way blocks were added
+// before they were tagged. Rules with another comment are the
+// operator's and are not listed.
+func (u *UFW) ListUntagged() ([]Rule, error) {
+	out, err := run(ufwPath, "status")
+	if err != nil {
+		return nil, err
+	}
+	return parseUFWRules(out, "DENY", ""), nil
+}
+
+// parses "ufw status" and returns every LIMIT rule carrying our limit
+// comment, e.g.
+//
+//	80/tcp                     LIMIT       203.0.113.9                # securemonitor-limit
+func (u *UFW) ListLimits() ([]Rule, error) {
+	out, err := run(ufwPath, "status")
+	if err != nil {
+		return nil, err
+	}
+	return parseUFWRules(out, "LIMIT", ufwLimitTag), nil
+}
+
+// returns the rules of "ufw status" output with the given action (DENY,
+// LIMIT) from a single source: those carrying tag, or those without
+// comment when tag is empty.
+func parseUFWRules(out, action, tag string) []Rule {
+	var rules []Rule
+	for _, line := range strings.Split(out, "\n") {
+		spec, comment, hasComment := strings.Cut(line, "#")
+		if tag != "" && (!hasComment || strings.TrimSpace(comment) != tag) {
+			continue
+		}
+		if tag == "" && hasComment {
+			continue
+		}
+
+		fields := strings.Fields(spec)
+		if len(fields) == 0 {
+			continue
+		}
+		to := fields[0]
+
+		for i, f := range fields {
+			if f != action || i+1 >= len(fields) {
+				continue
+			}
+			// "DENY IN <ip>" on newer ufw versions.
+			src := fields[i+1]
+			if src == "IN" && i+2 < len(fields) {
+				src = fields[i+2]
+			}
+
+			if _, _, err := classify(src); err != nil {
+				break // "Anywhere" and the like
+			}
+			r := Rule{IP: src}
+			if to != "Anywhere" {
+				r.Ports = []string{to}
+			}
+			rules = append(rules, r)
+			break
+		}
+	}
+	return mergeRules(rules)
+}
+
+// verifies ufw is installed and active.
+func (u *UFW) Check() error {
+	out, err := run(ufwPath, "status")
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(out, "Status: active") {
+		return fmt.Errorf("ufw is not active")
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package ipaddr
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// Parse extracts an IP address from a token as found in logs, headers or
+// config files. It accepts the forms we see in the wild:
+//
+//	203.0.113.7            2001:db8::1
+//	203.0.113.7:51234      [2001:db8::1]:51234   [2001:db8::1]
+//	fe80::1%eth0           ::ffff:203.0.113.7
+//
+// Zones are dropped and IPv4-mapped addresses are unmapped, so every
+// spelling of an address yields the same value. Hostnames and anything
+// else are rejected.
+func Parse(token string) (netip.Addr, bool) {
+	s := strings.TrimSpace(token)
+	s = strings.Trim(s, "\"'(),;")
+	if s == "" {
+		return netip.Addr{}, false
+	}
+
+	// [v6]:port or [v6]
+	if strings.HasPrefix(s, "[") {
+		end := strings.Index(s, "]")
+		if end < 0 {
+			return netip.Addr{}, false
+		}
+		s = s[1:end]
+	} else if strings.Count(s, ":") == 1 {
+		// v4:port
+		s, _, _ = strings.Cut(s, ":")
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.WithZone("").Unmap(), true
+}
+
+// Canonical returns the canonical text form of the address in token,
+// or "" when token is not an address.
+func Canonical(token string) string {
+	addr, ok := Parse(token)
+	if !ok {
+		return ""
+	}
+	return addr.String()
+}
+
+// CanonicalTarget is like Canonical but also accepts CIDR prefixes, which
+// are returned masked ("203.0.113.9/24" -> "203.0.113.0/24"). Host
+// prefixes (/32, /128) collapse to the plain address.
+func CanonicalTarget(s string) string {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		return Canonical(s)
+	}
+
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return ""
+	}
+	addr := p.Addr().WithZone("")
+	bits := p.Bits()
+	if addr.Is4In6() {
+		addr = addr.Unmap()
+		bits -= 96
+		if bits < 0 {
+			return ""
+		}
+	}
+	p = netip.PrefixFrom(addr, bits).Masked()
+	if p.IsSingleIP() {
+		return p.Addr().String()
+	}
+	return p.String()
+}
+
+// IsLocal reports whether addr is loopback, private, link-local or
+// unspecified: addresses that must never be blocked or geolocated.
+func IsLocal(addr netip.Addr) bool {
+	return addr.IsLoopback() ||
+		addr.IsPrivate() ||
+		addr.IsLinkLocalUnicast() ||
+		addr.IsUnspecified()
+}
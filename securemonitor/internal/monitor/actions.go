@@ -0,0 +1,128 @@
+package monitor
+
+import (
+	"fmt"
+
+	"securemonitor/internal/firewall"
+	"securemonitor/internal/storage"
+)
+
+// queues a block for an entry already stored as pending; the entry turns
+// active or failed once the firewall answers.
+func (eng *Engine) submitBlock(rule firewall.Rule) {
+	eng.submitBlockThen(rule, nil)
+}
+
+// is submitBlock, running then on the worker once the block is in place.
+func (eng *Engine) submitBlockThen(rule firewall.Rule, then func()) {
+	eng.actions.Submit("block", rule, func(job firewall.Job, err error) {
+		if err != nil {
+			eng.store.SetBlockState(rule.IP, storage.BlockFailed, storage.BlockPending)
+			eng.logActionFailed("block", job, err, fmt.Sprintf(
+				"[FW] Block of %s failed after %d attempts: %v",
+				rule.IP, job.Attempts, err,
+			))
+			return
+		}
+		eng.store.SetBlockState(rule.IP, storage.BlockActive, storage.BlockPending)
+		if then != nil {
+			then()
+		}
+	})
+}
+
+// queues the removal of a blocked entry. The entry stays visible as
+// "removing" until the rule is gone, and turns failed if it never goes.
+func (eng *Engine) submitUnblock(e storage.BlockedEntry) {
+	eng.store.SetBlockState(e.IP, storage.BlockRemoving)
+
+	rule := firewall.Rule{IP: e.IP, Ports: e.Ports, Service: e.Service, Strikes: e.Strikes}
+	eng.actions.Submit("unblock", rule, func(job firewall.Job, err error) {
+		if err != nil {
+			eng.store.SetBlockState(e.IP, storage.BlockFailed, storage.BlockRemoving)
+			eng.logActionFailed("unblock", job, err, fmt.Sprintf(
+				"[FW] Unblock of %s failed after %d attempts: %v",
+				e.IP, job.Attempts, err,
+			))
+			return
+		}
+		if eng.store.SetBlockState(e.IP, storage.BlockRemoving, storage.BlockRemoving) {
+			eng.store.RemoveBlocked(e.IP)
+		}
+	})
+}
+
+// queues a rate limit for an entry already stored as pending.
+func (eng *Engine) submitLimit(rule firewall.Rule) {
+	eng.actions.Submit("limit", rule, func(job firewall.Job, err error) {
+		if err != nil {
+			eng.store.SetLimitState(rule.IP, storage.BlockFailed, storage.BlockPending)
+			eng.logActionFailed("limit", job, err, fmt.Sprintf(
+				"[FW] Rate limit of %s failed after %d attempts: %v",
+				rule.IP, job.Attempts, err,
+			))
+			return
+		}
+		eng.store.SetLimitState(rule.IP, storage.BlockActive, storage.BlockPending)
+	})
+}
+
+// queues the removal of a rate limit; the entry is forgotten once the
+// limit is gone.
+func (eng *Engine) submitUnlimit(e storage.BlockedEntry) {
+	eng.store.SetLimitState(e.IP, storage.BlockRemoving)
+
+	rule := firewall.Rule{IP: e.IP, Ports: e.Ports, Service: e.Service, Strikes: e.Strikes}
+	eng.actions.Submit("unlimit", rule, func(job firewall.Job, err error) {
+		if err != nil {
+			eng.store.SetLimitState(e.IP, storage.BlockFailed, storage.BlockRemoving)
+			eng.logActionFailed("unlimit", job, err, fmt.Sprintf(
+				"[FW] Removing rate limit of %s failed after %d attempts: %v",
+				e.IP, job.Attempts, err,
+			))
+			return
+		}
+		if eng.store.SetLimitState(e.IP, storage.BlockRemoving, storage.BlockRemoving) {
+			eng.store.RemoveLimited(e.IP)
+		}
+	})
+}
+
+// records a firewall action that ran out of retries.
+func (eng *Engine) logActionFailed(op string, job firewall.Job, err error, message string) {
+	eng.store.Log(storage.LogEntry{
+		Level:     storage.LevelError,
+		Component: "firewall",
+		Event:     op + "_failed",
+		IP:        job.Rule.IP,
+		Fields:    storage.Fields{"attempts": job.Attempts, "error": err.Error()},
+		Message:   message,
+	})
+}
+
+// queues a firewall change that has no blocked entry attached (orphan
+// rules, superseded scoped rules).
+func (eng *Engine) submitRule(op string, rule firewall.Rule) {
+	eng.actions.Submit(op, rule, func(job firewall.Job, err error) {
+		if err != nil {
+			eng.logActionFailed(op, job, err, fmt.Sprintf(
+				"[FW] %s of %s failed after %d attempts: %v",
+				op, rule.IP, job.Attempts, err,
+			))
+		}
+	})
+}
+
+// queues the removal of a block (and of any rate limit) requested by an
+// operator. Unknown IPs are still removed from the firewall, in case a
+// rule exists there.
+func (eng *Engine) RequestUnblock(ip string) {
+	if e, ok := eng.store.GetLimited(ip); ok {
+		eng.submitUnlimit(e)
+	}
+	if e, ok := eng.store.GetBlocked(ip); ok {
+		eng.submitUnblock(e)
+		return
+	}
+	eng.submitRule("unblock", firewall.Rule{IP: ip})
+}
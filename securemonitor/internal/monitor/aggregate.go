@@ -0,0 +1,212 @@
+package monitor
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+
+	"securemonitor/internal/config"
+	"securemonitor/internal/firewall"
+	"securemonitor/internal/ipaddr"
+	"securemonitor/internal/storage"
+)
+
+// reports whether a blocked entry is an aggregated CIDR block.
+func isPrefixEntry(ip string) bool {
+	return strings.Contains(ip, "/")
+}
+
+// returns the aggregation prefix length and member threshold configured
+// for the address family of addr; threshold 0 means disabled.
+func aggregatePolicy(cfg config.Config, addr netip.Addr) (bits, threshold int) {
+	if addr.Is4() {
+		bits = cfg.AggregateV4Prefix
+		if bits <= 0 || bits > 32 {
+			bits = 24
+		}
+		return bits, cfg.AggregateV4Threshold
+	}
+
+	bits = cfg.AggregateV6Prefix
+	if bits <= 0 || bits > 128 {
+		bits = 64
+	}
+	return bits, cfg.AggregateV6Threshold
+}
+
+// returns the CIDR block covering ip among the given entries, if any.
+func coveringPrefix(ip string, entries []storage.BlockedEntry) (string, bool) {
+	addr, ok := ipaddr.Parse(ip)
+	if !ok {
+		return "", false
+	}
+
+	for _, e := range entries {
+		if !isPrefixEntry(e.IP) {
+			continue
+		}
+		p, err := netip.ParsePrefix(e.IP)
+		if err == nil && p.Contains(addr) {
+			return e.IP, true
+		}
+	}
+	return "", false
+}
+
+// promotes offenders to a CIDR block once enough addresses of the same
+// prefix were blocked within the aggregation window. The CIDR block gets
+// its own strikes and expiry, and its members are collapsed into it.
+// In global dry-run mode the same policy runs over the shadow blocks.
+// Prefixes containing a whitelisted address are never promoted.
+func (eng *Engine) aggregateSubnets(cfg config.Config, now time.Time, whitelist Whitelist) {
+	if cfg.AggregateV4Threshold <= 0 && cfg.AggregateV6Threshold <= 0 {
+		return
+	}
+
+	window := time.Duration(cfg.AggregateWindowMinutes) * time.Minute
+	if window <= 0 {
+		window = time.Hour
+	}
+
+	entries := eng.store.ListBlockedEntries()
+	if cfg.DryRun {
+		entries = eng.store.ListShadowBlocked()
+	}
+
+	// prefix -> members (all of them) and how many fall inside the window.
+	members := make(map[netip.Prefix][]storage.BlockedEntry)
+	recent := make(map[netip.Prefix]int)
+	covered := make(map[netip.Prefix]bool)
+
+	for _, e := range entries {
+		if isPrefixEntry(e.IP) {
+			if p, err := netip.ParsePrefix(e.IP); err == nil {
+				covered[p.Masked()] = true
+			}
+			continue
+		}
+
+		addr, ok := ipaddr.Parse(e.IP)
+		if !ok {
+			continue
+		}
+
+		bits, threshold := aggregatePolicy(cfg, addr)
+		if threshold <= 0 {
+			continue
+		}
+
+		p, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		members[p] = append(members[p], e)
+		if now.Sub(e.BlockedAt) <= window {
+			recent[p]++
+		}
+	}
+
+	prefixes := make([]netip.Prefix, 0, len(members))
+	for p := range members {
+		prefixes = append(prefixes, p)
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return prefixes[i].String() < prefixes[j].String() })
+
+	for _, p := range prefixes {
+		_, threshold := aggregatePolicy(cfg, p.Addr())
+		if covered[p] || recent[p] < threshold || whitelist.overlaps(p) {
+			continue
+		}
+		eng.promotePrefix(cfg, p, members[p], recent[p], now)
+	}
+}
+
+// blocks the CIDR and, once the subnet rule is in place, removes the
+// member entries it replaces. Members stay blocked if the CIDR block
+// fails.
+func (eng *Engine) promotePrefix(cfg config.Config, p netip.Prefix, members []storage.BlockedEntry, recent int, now time.Time) {
+	cidr := p.String()
+
+	// keep the members' scope when they all agree on service and ports,
+	// otherwise block the subnet host-wide.
+	service, ports := members[0].Service, members[0].Ports
+	for _, m := range members[1:] {
+		if m.Service != service || strings.Join(m.Ports, ",") != strings.Join(ports, ",") {
+			service, ports = "", nil
+			break
+		}
+	}
+	reason := fmt.Sprintf("%d addresses blocked in %s within window", recent, cidr)
+
+	if cfg.DryRun {
+		entry := eng.store.AddShadowBlocked(storage.BlockedEntry{IP: cidr, Service: service, Ports: ports})
+		eng.store.Log(storage.LogEntry{
+			Component: "firewall",
+			Event:     "would_aggregate",
+			IP:        cidr,
+			Fields:    storage.Fields{"reason": reason, "members": len(members), "strikes": entry.Strikes},
+			Message: fmt.Sprintf(
+				"[FW] [DRY-RUN] Would aggregate %s (%s, strikes=%d)",
+				cidr, reason, entry.Strikes,
+			),
+		})
+		eng.store.AddAlert(storage.Alert{
+			Timestamp: now.Format(time.RFC3339),
+			Service:   service,
+			IP:        cidr,
+			Severity:  "HIGH",
+			Message:   fmt.Sprintf("Would block subnet %s (%s)", cidr, reason),
+			DryRun:    true,
+		})
+		for _, m := range members {
+			eng.store.RemoveShadowBlocked(m.IP)
+		}
+		return
+	}
+
+	eng.store.Log(storage.LogEntry{
+		Level:     storage.LevelWarn,
+		Component: "firewall",
+		Event:     "aggregate",
+		IP:        cidr,
+		Fields:    storage.Fields{"reason": reason, "members": len(members)},
+		Message:   fmt.Sprintf("[FW] Aggregating %s (%s)", cidr, reason),
+	})
+
+	strikes := eng.store.NextStrikes(cidr)
+	rule := firewall.Rule{
+		IP:      cidr,
+		Timeout: banDuration(cfg, strikes),
+		Ports:   ports,
+		Service: service,
+		Strikes: strikes,
+	}
+	eng.store.AddBlocked(storage.BlockedEntry{
+		IP:        cidr,
+		Service:   service,
+		Ports:     ports,
+		State:     storage.BlockPending,
+		ExpiresAt: expiresAt(now, rule.Timeout),
+		Reason:    reason,
+		Source:    storage.SourceAggregate,
+	})
+	eng.submitBlockThen(rule, func() {
+		// collapse members: the subnet rule now covers them. Skip those
+		// that went away in the meantime.
+		for _, m := range members {
+			if e, ok := eng.store.GetBlocked(m.IP); ok {
+				eng.submitUnblock(e)
+			}
+		}
+	})
+
+	eng.store.AddAlert(storage.Alert{
+		Timestamp: now.Format(time.RFC3339),
+		Service:   service,
+		IP:        cidr,
+		Severity:  "HIGH",
+		Message:   fmt.Sprintf("Blocked subnet %s (%s)", cidr, reason),
+	})
+}
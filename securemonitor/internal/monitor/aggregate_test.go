@@ -0,0 +1,125 @@
+package monitor
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"securemonitor/internal/config"
+	"securemonitor/internal/firewall"
+	"securemonitor/internal/storage"
+)
+
+// orderedBackend records the calls it gets, in order, and fails the
+// blocks of the listed targets.
+type orderedBackend struct {
+	mu    sync.Mutex
+	calls []string
+	fail  map[string]bool
+}
+
+func (b *orderedBackend) Name() string { return "ordered" }
+
+func (b *orderedBackend) Block(r firewall.Rule) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.calls = append(b.calls, "block "+r.IP)
+	if b.fail[r.IP] {
+		return errors.New("refused")
+	}
+	return nil
+}
+
+func (b *orderedBackend) Unblock(r firewall.Rule) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.calls = append(b.calls, "unblock "+r.IP)
+	return nil
+}
+
+func (b *orderedBackend) List() ([]firewall.Rule, error) { return nil, nil }
+func (b *orderedBackend) Check() error                   { return nil }
+
+func (b *orderedBackend) recorded() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.calls)
+}
+
+// waits until cond holds, failing the test after a while.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPromotePrefix(t *testing.T) {
+	const cidr = "192.0.2.0/24"
+	members := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}
+
+	for _, failed := range []bool{false, true} {
+		name := "subnet blocked"
+		if failed {
+			name = "subnet block failed"
+		}
+		t.Run(name, func(t *testing.T) {
+			b := &orderedBackend{fail: map[string]bool{cidr: failed}}
+			prev := firewall.Active()
+			firewall.SetBackend(b)
+			t.Cleanup(func() { firewall.SetBackend(prev) })
+
+			store := storage.NewMemoryStore()
+			eng := &Engine{
+				store:   store,
+				actions: firewall.NewQueue(firewall.QueueOptions{Workers: 2, MaxAttempts: 1, Backoff: time.Millisecond}),
+			}
+			eng.actions.Start()
+
+			var entries []storage.BlockedEntry
+			for _, ip := range members {
+				entries = append(entries, store.AddBlocked(storage.BlockedEntry{IP: ip, State: storage.BlockActive}))
+			}
+			eng.promotePrefix(config.Config{}, netip.MustParsePrefix(cidr), entries, len(entries), time.Now())
+
+			if failed {
+				waitFor(t, "the subnet block to fail", func() bool {
+					e, _ := store.GetBlocked(cidr)
+					return e.State == storage.BlockFailed
+				})
+				if got := b.recorded(); !slices.Equal(got, []string{"block " + cidr}) {
+					t.Errorf("firewall calls = %v, want only the subnet block", got)
+				}
+				for _, ip := range members {
+					if e, ok := store.GetBlocked(ip); !ok || e.State != storage.BlockActive {
+						t.Errorf("member %s = %+v, want it still blocked", ip, e)
+					}
+				}
+				return
+			}
+
+			waitFor(t, "the members to be collapsed", func() bool {
+				for _, ip := range members {
+					if _, ok := store.GetBlocked(ip); ok {
+						return false
+					}
+				}
+				return true
+			})
+			calls := b.recorded()
+			if len(calls) != 1+len(members) || calls[0] != "block "+cidr {
+				t.Errorf("firewall calls = %v, want the subnet block before the member unblocks", calls)
+			}
+			if e, _ := store.GetBlocked(cidr); e.State != storage.BlockActive {
+				t.Errorf("subnet state = %q, want %q", e.State, storage.BlockActive)
+			}
+		})
+	}
+}
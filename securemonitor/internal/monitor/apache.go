@@ -3,6 +3,9 @@ package monitor
 import (
 	"log"
 	"strings"
+
+	"securemonitor/internal/ipaddr"
+	"securemonitor/internal/storage"
 )
 
 // reports whether the Apache access log line
@@ -18,24 +21,15 @@ func extractApacheAccessIP(line string) string {
 		return ""
 	}
 
-	ip := strings.TrimSpace(fields[0])
-	if ip == "-" {
-		return ""
-	}
-	return ip
+	return ipaddr.Canonical(fields[0])
 }
 
-// reads new lines from the access log and returns
-func parseApacheErrors(path string) map[string]int {
-	lines, err := ReadNewLines(path)
-	if err != nil || len(lines) == 0 {
-		return map[string]int{}
-	}
-
+// counts the error responses per client IP in access log lines.
+func (eng *Engine) parseApacheErrorsFromLines(lines []logLine) map[string]int {
 	errorsByIP := make(map[string]int)
 
 	for _, raw := range lines {
-		line := strings.TrimSpace(raw)
+		line := strings.TrimSpace(raw.Text)
 		if line == "" {
 			continue
 		}
@@ -49,10 +43,14 @@ func parseApacheErrors(path string) map[string]int {
 			ip = extractIP(line)
 		}
 		if ip == "" {
-			ip = "unknown"
+			// never count (or block) something that is not an address.
+			log.Printf("apache: no valid client ip, skipping: %s", line)
+			continue
 		}
 
 		errorsByIP[ip]++
+		eng.store.AddMatchedLine(ip, storage.MatchedLine{Time: raw.Time, Service: "apache", Host: raw.Host, Line: line})
+		eng.noteEventHost("apache", ip, raw.Host)
 		log.Printf("apache: matched error from %s: %s", ip, line)
 	}
 
@@ -0,0 +1,172 @@
+package monitor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// rotatedFile is a sibling left by logrotate: auth.log.1, auth.log.2.gz,
+// access.log-20240101 and the like.
+type rotatedFile struct {
+	path string
+	info os.FileInfo
+	gz   bool
+
+	numbered bool  // ".N" suffix: higher is older
+	seq      int64 // N, or the date of a dateext suffix
+}
+
+// returns the rotated siblings of path modified after cutoff, oldest
+// first.
+func rotatedSiblings(path string, cutoff time.Time) []rotatedFile {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	pattern := regexp.MustCompile(`^` + regexp.QuoteMeta(name) + `([.-])([0-9]+)(\.gz)?$`)
+	var out []rotatedFile
+	for _, e := range entries {
+		m := pattern.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || info.ModTime().Before(cutoff) {
+			continue
+		}
+		seq, _ := strconv.ParseInt(m[2], 10, 64)
+		out = append(out, rotatedFile{
+			path:     filepath.Join(dir, e.Name()),
+			info:     info,
+			gz:       m[3] != "",
+			numbered: m[1] == ".",
+			seq:      seq,
+		})
+	}
+
+	// order by the rotation suffix, which compression does not touch,
+	// and by modification time when the schemes are mixed or tie.
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		switch {
+		case a.numbered && b.numbered && a.seq != b.seq:
+			return a.seq > b.seq
+		case !a.numbered && !b.numbered && a.seq != b.seq:
+			return a.seq < b.seq
+		}
+		return a.info.ModTime().Before(b.info.ModTime())
+	})
+	return out
+}
+
+// opens the file, decompressing it when it is gzipped.
+func (r rotatedFile) open() (io.ReadCloser, error) {
+	f, err := os.Open(r.path)
+	if err != nil || !r.gz {
+		return f, err
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{zr, f}, nil
+}
+
+// reports whether the rotated file is the one st was saved for: same
+// inode for a plain file, same head otherwise (compression makes a new
+// file).
+func (r rotatedFile) matches(st tailState) bool {
+	if !r.gz && st.Ino != 0 {
+		if id, ok := fileID(r.info); ok {
+			return id == fileKey{Dev: st.Dev, Ino: st.Ino}
+		}
+	}
+	if st.HeadLen == 0 {
+		return false
+	}
+
+	rc, err := r.open()
+	if err != nil {
+		return false
+	}
+	defer rc.Close()
+	head := make([]byte, st.HeadLen)
+	n, _ := io.ReadFull(rc, head)
+	hash, _, _ := headHash(bytes.NewReader(head[:n]), int64(n))
+	return n == st.HeadLen && hash == st.HeadHash
+}
+
+// opens the rotated file positioned at offset.
+func (r rotatedFile) openAt(offset int64) (io.ReadCloser, error) {
+	rc, err := r.open()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, rc, offset); err != nil && err != io.EOF {
+		rc.Close()
+		return nil, err
+	}
+	return rc, nil
+}
+
+// returns the rotated siblings of the path still to read past st, oldest
+// first: the rest of the file st was saved for, unless skipSaved is set
+// because the caller still holds it, then every newer sibling. When
+// that file cannot be found, the siblings written to since st was saved
+// are read whole.
+func (f *follower) catchUp(st tailState, skipSaved bool) []*pendingFile {
+	if f.lookBack <= 0 {
+		return nil
+	}
+	sibs := rotatedSiblings(f.path, time.Now().Add(-f.lookBack))
+
+	offset := int64(0)
+	start := -1
+	for i, r := range sibs {
+		if r.matches(st) {
+			start = i
+		}
+	}
+	switch {
+	case start >= 0 && skipSaved:
+		start++
+	case start >= 0:
+		offset = st.Offset
+	default:
+		for start = 0; start < len(sibs); start++ {
+			if sibs[start].info.ModTime().After(st.SavedAt) {
+				break
+			}
+		}
+	}
+
+	var out []*pendingFile
+	for i := start; i < len(sibs); i++ {
+		rc, err := sibs[i].openAt(offset)
+		offset = 0
+		if err != nil {
+			log.Printf("monitor: cannot catch up on %s: %v", sibs[i].path, err)
+			continue
+		}
+		log.Printf("monitor: catching up on %s", sibs[i].path)
+		out = append(out, &pendingFile{rc: rc, lines: newLineReader(rc)})
+	}
+	return out
+}
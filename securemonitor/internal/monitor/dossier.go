@@ -0,0 +1,69 @@
+package monitor
+
+import (
+	"time"
+
+	"securemonitor/internal/ipaddr"
+	"securemonitor/internal/storage"
+)
+
+// Dossier gathers everything known about one address.
+type Dossier struct {
+	IP          string `json:"ip"`
+	Country     string `json:"country"`
+	Local       bool   `json:"local"`
+	Whitelisted bool   `json:"whitelisted"`
+
+	// accumulated events per service since the daemon started.
+	FailureTotals map[string]int `json:"failure_totals"`
+
+	Block      *storage.BlockedEntry `json:"block"`       // current block, if any
+	CoveredBy  string                `json:"covered_by"`  // aggregated subnet block containing the IP
+	Limit      *storage.BlockedEntry `json:"limit"`       // current rate limit, if any
+	WouldBlock *storage.BlockedEntry `json:"would_block"` // dry-run decision, if any
+	PastBlocks []storage.PastBlock   `json:"past_blocks"`
+	Strikes    []time.Time           `json:"strikes"` // strikes still counting
+
+	Alerts       []storage.Alert       `json:"alerts"`
+	Logs         []storage.LogEntry    `json:"logs"`
+	MatchedLines []storage.MatchedLine `json:"matched_lines"`
+}
+
+// collects the dossier of a canonical IP address.
+func (eng *Engine) BuildDossier(ip string) (Dossier, error) {
+	d := Dossier{
+		IP:            ip,
+		Country:       lookupCountry(ip),
+		FailureTotals: eng.FailureTotals(ip),
+		PastBlocks:    eng.store.PastBlocks(ip),
+		Strikes:       eng.store.StrikeHistory(ip),
+		Alerts:        []storage.Alert{},
+		Logs:          eng.store.QueryLogs(storage.LogQuery{IP: ip}),
+		MatchedLines:  eng.store.GetMatchedLines(ip),
+	}
+
+	if addr, ok := ipaddr.Parse(ip); ok {
+		d.Local = ipaddr.IsLocal(addr)
+	}
+
+	d.Whitelisted = isWhitelisted(ip, loadWhitelist(eng.cfg.WhitelistFile))
+
+	if e, ok := eng.store.GetBlocked(ip); ok {
+		d.Block = &e
+	}
+	if cidr, ok := coveringPrefix(ip, eng.store.ListBlockedEntries()); ok {
+		d.CoveredBy = cidr
+	}
+	if e, ok := eng.store.GetLimited(ip); ok {
+		d.Limit = &e
+	}
+	if e, ok := eng.store.GetShadowBlocked(ip); ok {
+		d.WouldBlock = &e
+	}
+
+	err := eng.store.ScanAlerts(storage.AlertQuery{IP: ip}, func(a storage.Alert) error {
+		d.Alerts = append(d.Alerts, a)
+		return nil
+	})
+	return d, err
+}
@@ -0,0 +1,236 @@
+package monitor
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"securemonitor/internal/config"
+	"securemonitor/internal/firewall"
+	"securemonitor/internal/storage"
+)
+
+// returns the ports a block decided by the service is scoped to: none
+// (host-wide) unless block_scope is "service".
+func scopedPorts(cfg config.Config, ports []string) []string {
+	if !strings.EqualFold(cfg.BlockScope, "service") {
+		return nil
+	}
+	return ports
+}
+
+// returns the ports of want that the existing scope does not cover yet,
+// and whether anything is missing at all. An empty port list is host-wide.
+func missingPorts(existing, want []string) ([]string, bool) {
+	if len(existing) == 0 {
+		return nil, false
+	}
+	if len(want) == 0 {
+		return nil, true
+	}
+
+	var missing []string
+	for _, p := range want {
+		covered := false
+		for _, q := range existing {
+			if p == q {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			missing = append(missing, p)
+		}
+	}
+	return missing, len(missing) > 0
+}
+
+// applies a block decision for the service, or only records it as a
+// "would block" when the service runs in dry-run mode.
+// ports are the ones the service declares; the block is scoped to them
+// when block_scope is "service". reason ends up in the log line,
+// e.g. "total fails=5, threshold=3".
+func (eng *Engine) blockIP(cfg config.Config, service string, ports []string, ip, reason string, now time.Time) {
+	prefix := logPrefix(service)
+	ports = scopedPorts(cfg, ports)
+
+	if cfg.IsDryRun(service) {
+		if existing, ok := eng.store.GetShadowBlocked(ip); ok {
+			if _, more := missingPorts(existing.Ports, ports); !more {
+				return
+			}
+		}
+		if _, ok := coveringPrefix(ip, eng.store.ListShadowBlocked()); ok {
+			return
+		}
+
+		entry := eng.store.AddShadowBlocked(storage.BlockedEntry{IP: ip, Service: service, Ports: ports})
+		ban := banDuration(cfg, entry.Strikes)
+
+		eng.store.Log(storage.LogEntry{
+			Component: service,
+			Event:     "would_block",
+			IP:        ip,
+			Host:      eng.eventHost(service, ip),
+			Fields: storage.Fields{
+				"reason":  reason,
+				"scope":   describeScope(entry.Ports),
+				"strikes": entry.Strikes,
+				"ban":     ban.String(),
+			},
+			Message: fmt.Sprintf(
+				"%s [DRY-RUN] Would block %s (%s, scope=%s, strikes=%d, ban≈%s)",
+				prefix, ip, reason, describeScope(entry.Ports), entry.Strikes, ban,
+			),
+		})
+		eng.store.AddAlert(storage.Alert{
+			Timestamp: now.Format(time.RFC3339),
+			Service:   service,
+			IP:        ip,
+			Host:      eng.eventHost(service, ip),
+			Country:   lookupCountry(ip),
+			Severity:  "HIGH",
+			Message:   fmt.Sprintf("Would block %s (%s)", ip, reason),
+			DryRun:    true,
+		})
+		return
+	}
+
+	// already covered by an aggregated subnet block.
+	if _, ok := coveringPrefix(ip, eng.store.ListBlockedEntries()); ok {
+		return
+	}
+
+	strikes := eng.store.NextStrikes(ip)
+	rule := firewall.Rule{
+		IP:      ip,
+		Timeout: banDuration(cfg, strikes),
+		Ports:   ports,
+		Service: service,
+		Strikes: strikes,
+	}
+
+	// Already blocked: only widen the scope, for the remaining ban time.
+	existing, blocked := eng.store.GetBlocked(ip)
+	if blocked {
+		missing, more := missingPorts(existing.Ports, ports)
+		if !more {
+			return
+		}
+		if rule.Timeout > 0 {
+			rule.Timeout -= now.Sub(existing.BlockedAt)
+			if rule.Timeout < time.Second {
+				rule.Timeout = time.Second
+			}
+		}
+		if len(ports) > 0 {
+			rule.Ports = missing
+		}
+	}
+
+	eng.store.Log(storage.LogEntry{
+		Level:     storage.LevelWarn,
+		Component: service,
+		Event:     "block",
+		IP:        ip,
+		Host:      eng.eventHost(service, ip),
+		Fields:    storage.Fields{"reason": reason, "scope": describeScope(rule.Ports), "strikes": strikes},
+		Message:   fmt.Sprintf("%s Blocking %s (%s, scope=%s)", prefix, ip, reason, describeScope(rule.Ports)),
+	})
+
+	eng.store.AddBlocked(storage.BlockedEntry{
+		IP:        ip,
+		Service:   service,
+		Ports:     ports,
+		State:     storage.BlockPending,
+		ExpiresAt: expiresAt(now, rule.Timeout),
+		Reason:    reason,
+		Source:    storage.SourceMonitor,
+	})
+	eng.submitBlock(rule)
+
+	// a host-wide block supersedes the scoped rules installed before.
+	if blocked && len(rule.Ports) == 0 {
+		eng.submitRule("unblock", firewall.Rule{IP: ip, Ports: existing.Ports, Service: existing.Service, Strikes: existing.Strikes})
+	}
+}
+
+// returns the expiry of a ban lasting d from now; zero when it does not
+// expire.
+func expiresAt(now time.Time, d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return now.Add(d)
+}
+
+// formats a port list for logs: "host" or "service 22/tcp".
+func describeScope(ports []string) string {
+	if len(ports) == 0 {
+		return "host"
+	}
+	return "service " + strings.Join(ports, ",")
+}
+
+// lifts every shadow block older than its ban duration, recording it as
+// a "would unblock".
+func (eng *Engine) expireShadowBlocks(cfg config.Config, now time.Time) {
+	for _, e := range eng.store.ListShadowBlocked() {
+		maxAge := banDuration(cfg, e.Strikes)
+		age := now.Sub(e.BlockedAt)
+		if age < maxAge {
+			continue
+		}
+
+		eng.store.Log(storage.LogEntry{
+			Component: "firewall",
+			Event:     "would_unblock",
+			IP:        e.IP,
+			Fields:    storage.Fields{"age": age.Truncate(time.Second).String(), "strikes": e.Strikes},
+			Message: fmt.Sprintf(
+				"[FW] [DRY-RUN] Would auto-unblock %s (age=%s, strikes=%d, maxAge≈%s)",
+				e.IP, age.Truncate(time.Second), e.Strikes, maxAge.Truncate(time.Second),
+			),
+		})
+		eng.store.AddAlert(storage.Alert{
+			Timestamp: now.Format(time.RFC3339),
+			Service:   e.Service,
+			IP:        e.IP,
+			Severity:  "LOW",
+			Message:   fmt.Sprintf("Would unblock %s after %s", e.IP, age.Truncate(time.Second)),
+			DryRun:    true,
+		})
+		eng.store.RemoveShadowBlocked(e.IP)
+	}
+}
+
+// records, once per block, that a real block would have been lifted while
+// the firewall is frozen by dry-run mode.
+func (eng *Engine) noteWouldUnblock(e storage.BlockedEntry, age time.Duration, now time.Time) {
+	eng.wouldUnblockMu.Lock()
+	defer eng.wouldUnblockMu.Unlock()
+
+	if at, ok := eng.wouldUnblockNoted[e.IP]; ok && at.Equal(e.BlockedAt) {
+		return
+	}
+	eng.wouldUnblockNoted[e.IP] = e.BlockedAt
+
+	eng.store.Log(storage.LogEntry{
+		Component: "firewall",
+		Event:     "would_unblock",
+		IP:        e.IP,
+		Fields:    storage.Fields{"age": age.Truncate(time.Second).String(), "strikes": e.Strikes},
+		Message: fmt.Sprintf(
+			"[FW] [DRY-RUN] Would auto-unblock %s (age=%s, strikes=%d)",
+			e.IP, age.Truncate(time.Second), e.Strikes,
+		),
+	})
+	eng.store.AddAlert(storage.Alert{
+		Timestamp: now.Format(time.RFC3339),
+		Service:   strings.ToLower(e.Service),
+		IP:        e.IP,
+		Severity:  "LOW",
+		Message:   fmt.Sprintf("Would unblock %s after %s", e.IP, age.Truncate(time.Second)),
+		DryRun:    true,
+	})
+}
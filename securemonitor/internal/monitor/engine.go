@@ -0,0 +1,120 @@
+package monitor
+
+import (
+	"sync"
+	"time"
+
+	"securemonitor/internal/config"
+	"securemonitor/internal/firewall"
+	"securemonitor/internal/storage"
+)
+
+// Engine runs the monitor: it reads the logs and remote inputs, hands
+// the events to the service strategies and keeps the firewall in line
+// with the store.
+type Engine struct {
+	cfg     config.Config // the configuration Run works with
+	store   storage.Store // blocks, strikes, alerts and logs
+	actions *firewall.Queue
+
+	ssh, ftp, apache ServiceStrategy
+
+	// serializes enforcement: a scan cycle and a reconciliation never
+	// run at the same time, otherwise a block applied but not yet stored
+	// would look like drift.
+	enforceMu sync.Mutex
+	// whether untagged rules were looked for; once per process. Guarded
+	// by enforceMu.
+	legacyChecked bool
+	// whitelist entries already reported as invalid. Guarded by
+	// enforceMu.
+	whitelistWarned map[string]bool
+
+	driftMu   sync.Mutex
+	lastDrift DriftReport
+
+	// followed log files by path, the offsets loaded at startup, and how
+	// the files are read. Guarded by followersMu.
+	followersMu sync.Mutex
+	followers   map[string]*follower
+	savedTails  map[string]tailState
+	// how far back rotated siblings are read; 0 disables the catch-up.
+	catchUpLookBack time.Duration
+	// bytes read per file and cycle; the rest waits for the next one.
+	readLimit int64
+
+	journalMu      sync.Mutex
+	journalSources []*journalSource
+
+	// messages received for each service until the loop drains them.
+	syslogMu      sync.Mutex
+	syslogQueue   map[string][]logLine
+	syslogQueued  int
+	syslogDropped int
+
+	// hosts that logged the events of the current cycle, by service and
+	// IP. The parsers fill it from remote lines (syslog) so the logs and
+	// alerts of the cycle can name where the attack was seen.
+	eventHostsMu sync.Mutex
+	eventHosts   map[string]map[string]map[string]struct{}
+
+	// real blocks whose "would unblock" was already recorded in dry-run
+	// mode, keyed by IP with the BlockedAt of the entry, so it is reported
+	// once.
+	wouldUnblockMu    sync.Mutex
+	wouldUnblockNoted map[string]time.Time
+
+	// limits already reported as "would limit" in dry-run mode, keyed by
+	// IP with the time of the report, so each is reported once per limit
+	// period.
+	wouldLimitMu    sync.Mutex
+	wouldLimitNoted map[string]time.Time
+
+	// wakes a waiting logWatcher from inputs that are not files.
+	wake chan struct{}
+}
+
+// builds an engine recording into store and starts its firewall action
+// queue. The firewall backend must be selected before.
+func New(cfg config.Config, store storage.Store) *Engine {
+	eng := &Engine{
+		cfg:   cfg,
+		store: store,
+		actions: firewall.NewQueue(firewall.QueueOptions{
+			Workers:     cfg.FirewallWorkers,
+			MaxAttempts: cfg.FirewallMaxAttempts,
+			Backoff:     time.Duration(cfg.FirewallRetryBackoffSeconds) * time.Second,
+			FailedFile:  cfg.FailedActionsFile,
+		}),
+		whitelistWarned:   make(map[string]bool),
+		followers:         make(map[string]*follower),
+		savedTails:        make(map[string]tailState),
+		syslogQueue:       make(map[string][]logLine),
+		eventHosts:        make(map[string]map[string]map[string]struct{}),
+		wouldUnblockNoted: make(map[string]time.Time),
+		wouldLimitNoted:   make(map[string]time.Time),
+		wake:              make(chan struct{}, 1),
+	}
+	eng.ssh = NewSSHStrategy(eng)
+	eng.ftp = NewFTPStrategy(eng)
+	eng.apache = NewApacheStrategy(eng)
+	eng.configureFollowers(cfg)
+
+	eng.actions.Start()
+	return eng
+}
+
+// returns the store the engine records into.
+func (eng *Engine) Store() storage.Store {
+	return eng.store
+}
+
+// returns the firewall action queue.
+func (eng *Engine) ActionQueue() *firewall.Queue {
+	return eng.actions
+}
+
+// returns the strategies Run hands events to.
+func (eng *Engine) strategies() []ServiceStrategy {
+	return []ServiceStrategy{eng.ssh, eng.ftp, eng.apache}
+}
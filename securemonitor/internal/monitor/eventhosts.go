@@ -0,0 +1,54 @@
+package monitor
+
+import (
+	"sort"
+	"strings"
+)
+
+// forgets the hosts of the previous cycle.
+func (eng *Engine) resetEventHosts() {
+	eng.eventHostsMu.Lock()
+	defer eng.eventHostsMu.Unlock()
+	eng.eventHosts = make(map[string]map[string]map[string]struct{})
+}
+
+// records that host logged an event of service for ip. Local lines have
+// no host and are not recorded.
+func (eng *Engine) noteEventHost(service, ip, host string) {
+	if host == "" {
+		return
+	}
+
+	eng.eventHostsMu.Lock()
+	defer eng.eventHostsMu.Unlock()
+
+	byIP, ok := eng.eventHosts[service]
+	if !ok {
+		byIP = make(map[string]map[string]struct{})
+		eng.eventHosts[service] = byIP
+	}
+	hosts, ok := byIP[ip]
+	if !ok {
+		hosts = make(map[string]struct{})
+		byIP[ip] = hosts
+	}
+	hosts[host] = struct{}{}
+}
+
+// returns the hosts that logged events of service for ip this cycle,
+// sorted and comma-separated; "" when all came from local logs.
+func (eng *Engine) eventHost(service, ip string) string {
+	eng.eventHostsMu.Lock()
+	defer eng.eventHostsMu.Unlock()
+
+	hosts := eng.eventHosts[service][ip]
+	if len(hosts) == 0 {
+		return ""
+	}
+	out := make([]string, 0, len(hosts))
+	for h := range hosts {
+		out = append(out, h)
+	}
+	sort.Strings(out)
+	return strings.Join(out, ",")
+}
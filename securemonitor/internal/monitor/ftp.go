@@ -1,20 +1,24 @@
 package monitor
 
-import "strings"
+import (
+	"strings"
+
+	"securemonitor/internal/storage"
+)
 
 // parseFTPFailuresFromLines aggregates failed FTP login attempts per IP
 // from raw log lines (e.g. vsftpd logs).
-func parseFTPFailuresFromLines(lines []string) map[string]int {
+func (eng *Engine) parseFTPFailuresFromLines(lines []logLine) map[string]int {
 	failures := make(map[string]int)
 
 	for _, raw := range lines {
-		line := strings.TrimSpace(raw)
+		line := strings.TrimSpace(raw.Text)
 		if line == "" {
 			continue
 		}
 
 		// Only consider FTP entries from vsftpd.
-		if !strings.Contains(line, "vsftpd") {
+		if !raw.from("vsftpd") {
 			continue
 		}
 
@@ -23,18 +27,11 @@ func parseFTPFailuresFromLines(lines []string) map[string]int {
 			ip := extractIP(line)
 			if ip != "" {
 				failures[ip]++
+				eng.store.AddMatchedLine(ip, storage.MatchedLine{Time: raw.Time, Service: "ftp", Host: raw.Host, Line: line})
+				eng.noteEventHost("ftp", ip, raw.Host)
 			}
 		}
 	}
 
 	return failures
 }
-
-// parseFTPFailures reads new FTP log lines from disk and returns per-IP failure counts.
-func parseFTPFailures(path string) map[string]int {
-	lines, err := ReadNewLines(path)
-	if err != nil || len(lines) == 0 {
-		return map[string]int{}
-	}
-	return parseFTPFailuresFromLines(lines)
-}
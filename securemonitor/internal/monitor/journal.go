@@ -0,0 +1,379 @@
+package monitor
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"securemonitor/internal/config"
+	"securemonitor/internal/storage"
+)
+
+// journalEntry is one record of the systemd journal.
+type journalEntry struct {
+	Cursor  string
+	Time    time.Time
+	Unit    string // _SYSTEMD_UNIT
+	Ident   string // SYSLOG_IDENTIFIER
+	Message string
+}
+
+// journalSource collects the entries of one configured journal input
+// until the monitor loop drains them.
+type journalSource struct {
+	cfg  config.JournalSource
+	name string
+	wake func() // called when an entry is queued
+
+	mu      sync.Mutex
+	queue   []journalEntry
+	cursor  string // of the last entry handed to the parsers
+	dropped int
+}
+
+const (
+	maxJournalQueue = 100000 // entries kept between two scans
+	journalRetry    = 10 * time.Second
+)
+
+// starts reading every configured journal source, resuming after the
+// cursors saved by the previous run.
+func (eng *Engine) startJournal(cfg config.Config) {
+	if len(cfg.JournalSources) == 0 {
+		return
+	}
+
+	cursors, err := loadJournalCursors(cfg.JournalCursorPath())
+	if err != nil {
+		log.Printf("journal: ignoring saved cursors: %v", err)
+	}
+
+	eng.journalMu.Lock()
+	defer eng.journalMu.Unlock()
+
+	for _, jc := range cfg.JournalSources {
+		s := &journalSource{cfg: jc, name: jc.Name, wake: eng.wakeLoop}
+		if s.name == "" {
+			s.name = jc.Service
+		}
+		s.cursor = cursors[s.name]
+		eng.journalSources = append(eng.journalSources, s)
+
+		if jc.ExportFile != "" {
+			go s.readExport(jc.ExportFile, s.cursor)
+			continue
+		}
+		if _, err := exec.LookPath("journalctl"); err != nil {
+			log.Printf("journal %s: journalctl not found, source disabled", s.name)
+			continue
+		}
+		go s.follow(s.cursor)
+	}
+}
+
+// returns the entries collected for service since the last call as
+// parser lines, oldest first.
+func (eng *Engine) drainJournal(service string) []logLine {
+	eng.journalMu.Lock()
+	sources := eng.journalSources
+	eng.journalMu.Unlock()
+
+	var out []logLine
+	for _, s := range sources {
+		if s.cfg.Service != service {
+			continue
+		}
+
+		s.mu.Lock()
+		queue := s.queue
+		s.queue = nil
+		if len(queue) > 0 {
+			s.cursor = queue[len(queue)-1].Cursor
+		}
+		if s.dropped > 0 {
+			log.Printf("journal %s: dropped %d entries, the scan loop fell behind", s.name, s.dropped)
+			s.dropped = 0
+		}
+		s.mu.Unlock()
+
+		for _, e := range queue {
+			out = append(out, logLine{Time: e.Time, Program: e.Ident, Text: e.Message})
+		}
+	}
+	return out
+}
+
+// reports whether the entry is one the source asked for.
+func (s *journalSource) matches(e journalEntry) bool {
+	if len(s.cfg.Units) == 0 && len(s.cfg.Identifiers) == 0 {
+		return true
+	}
+	for _, u := range s.cfg.Units {
+		if e.Unit == u {
+			return true
+		}
+	}
+	for _, id := range s.cfg.Identifiers {
+		if e.Ident == id {
+			return true
+		}
+	}
+	return false
+}
+
+// queues an entry for the next scan and wakes the loop.
+func (s *journalSource) push(e journalEntry) {
+	if !s.matches(e) {
+		return
+	}
+
+	s.mu.Lock()
+	s.queue = append(s.queue, e)
+	if len(s.queue) > maxJournalQueue {
+		s.queue = s.queue[1:]
+		s.dropped++
+	}
+	s.mu.Unlock()
+
+	s.wake()
+}
+
+// returns the journalctl arguments following the source after cursor,
+// or from now on without one.
+func (s *journalSource) args(after string) []string {
+	args := []string{"-o", "json", "--follow", "--no-pager"}
+	if after != "" {
+		args = append(args, "--after-cursor="+after)
+	} else {
+		args = append(args, "--lines=0")
+	}
+
+	// matches on the same field are ORed, "+" ORs the two groups.
+	for _, u := range s.cfg.Units {
+		args = append(args, "_SYSTEMD_UNIT="+u)
+	}
+	if len(s.cfg.Units) > 0 && len(s.cfg.Identifiers) > 0 {
+		args = append(args, "+")
+	}
+	for _, id := range s.cfg.Identifiers {
+		args = append(args, "SYSLOG_IDENTIFIER="+id)
+	}
+	return args
+}
+
+// runs journalctl --follow for as long as the daemon lives, restarting
+// it after the last entry seen when it exits.
+func (s *journalSource) follow(after string) {
+	for {
+		cmd := exec.Command("journalctl", s.args(after)...)
+		out, err := cmd.StdoutPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			log.Printf("journal %s: cannot start journalctl: %v", s.name, err)
+			time.Sleep(journalRetry)
+			continue
+		}
+		log.Printf("journal %s: following %s", s.name, strings.Join(cmd.Args, " "))
+
+		sc := bufio.NewScanner(out)
+		sc.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+		for sc.Scan() {
+			e, err := parseJournalJSON(sc.Bytes())
+			if err != nil {
+				continue
+			}
+			after = e.Cursor
+			s.push(e)
+		}
+
+		err = cmd.Wait()
+		log.Printf("journal %s: journalctl exited (%v), restarting", s.name, err)
+		time.Sleep(journalRetry)
+	}
+}
+
+// reads the entries of a journal export file (journalctl -o export)
+// after cursor, or all of them when the cursor is not in the file.
+func (s *journalSource) readExport(path, after string) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("journal %s: %v", s.name, err)
+		return
+	}
+	defer f.Close()
+
+	var entries []journalEntry
+	err = readJournalExport(f, func(e journalEntry) {
+		entries = append(entries, e)
+	})
+	if err != nil {
+		log.Printf("journal %s: %s: %v", s.name, path, err)
+	}
+
+	for i, e := range entries {
+		if after != "" && e.Cursor == after {
+			entries = entries[i+1:]
+			break
+		}
+	}
+	for _, e := range entries {
+		s.push(e)
+	}
+	log.Printf("journal %s: read %d entries from %s", s.name, len(entries), path)
+}
+
+// decodes one line of journalctl -o json.
+func parseJournalJSON(line []byte) (journalEntry, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(line, &raw); err != nil {
+		return journalEntry{}, err
+	}
+
+	fields := make(map[string]string, len(raw))
+	for k, v := range raw {
+		fields[k] = journalValue(v)
+	}
+	return entryFromFields(fields), nil
+}
+
+// returns a JSON field value as text: journalctl writes a string, an
+// array of byte values for non-UTF-8 data, or an array of strings for
+// repeated fields (the first one is kept).
+func journalValue(v json.RawMessage) string {
+	var s string
+	if json.Unmarshal(v, &s) == nil {
+		return s
+	}
+	var many []string
+	if json.Unmarshal(v, &many) == nil && len(many) > 0 {
+		return many[0]
+	}
+	var bytes []int
+	if json.Unmarshal(v, &bytes) == nil {
+		b := make([]byte, len(bytes))
+		for i, c := range bytes {
+			b[i] = byte(c)
+		}
+		return string(b)
+	}
+	return ""
+}
+
+// reads the journal export format: "FIELD=value" lines, binary fields
+// as the name, a little-endian 64-bit size, the data and a newline, and
+// a blank line after each entry.
+func readJournalExport(r io.Reader, fn func(journalEntry)) error {
+	br := bufio.NewReader(r)
+	fields := make(map[string]string)
+	flush := func() {
+		if len(fields) > 0 {
+			fn(entryFromFields(fields))
+			fields = make(map[string]string)
+		}
+	}
+
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF && line == "" {
+			flush()
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		if line == "" {
+			flush()
+			continue
+		}
+		if i := strings.IndexByte(line, '='); i >= 0 {
+			fields[line[:i]] = line[i+1:]
+			continue
+		}
+
+		var size uint64
+		if err := binary.Read(br, binary.LittleEndian, &size); err != nil {
+			return fmt.Errorf("field %s: %w", line, err)
+		}
+		if size > 64<<20 {
+			return fmt.Errorf("field %s: %d bytes is too large", line, size)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return fmt.Errorf("field %s: %w", line, err)
+		}
+		br.ReadByte() // trailing newline
+		fields[line] = string(data)
+	}
+}
+
+// builds an entry from its journal fields. The time is when journald
+// received the entry.
+func entryFromFields(fields map[string]string) journalEntry {
+	e := journalEntry{
+		Cursor:  fields["__CURSOR"],
+		Unit:    fields["_SYSTEMD_UNIT"],
+		Ident:   fields["SYSLOG_IDENTIFIER"],
+		Message: fields["MESSAGE"],
+	}
+	if us, err := strconv.ParseInt(fields["__REALTIME_TIMESTAMP"], 10, 64); err == nil {
+		e.Time = time.UnixMicro(us)
+	}
+	return e
+}
+
+// reads the cursors saved by a previous run, by source name.
+func loadJournalCursors(path string) (map[string]string, error) {
+	cursors := make(map[string]string)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cursors, nil
+	}
+	if err != nil {
+		return cursors, err
+	}
+	if err := json.Unmarshal(data, &cursors); err != nil {
+		return make(map[string]string), fmt.Errorf("%s: %w", path, err)
+	}
+	return cursors, nil
+}
+
+// writes the cursor of the last entry handed to the parsers for every
+// source, so a restart resumes right after it.
+func (eng *Engine) saveJournalCursors(path string) error {
+	eng.journalMu.Lock()
+	sources := eng.journalSources
+	eng.journalMu.Unlock()
+	if len(sources) == 0 {
+		return nil
+	}
+
+	cursors := make(map[string]string, len(sources))
+	for _, s := range sources {
+		s.mu.Lock()
+		if s.cursor != "" {
+			cursors[s.name] = s.cursor
+		}
+		s.mu.Unlock()
+	}
+
+	data, err := json.MarshalIndent(cursors, "", "  ")
+	if err != nil {
+		return err
+	}
+	return storage.WriteFileAtomic(path, append(data, '\n'))
+}
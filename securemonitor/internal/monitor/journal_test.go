@@ -0,0 +1,123 @@
+package monitor
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// returns a binary export field: name, little-endian size, data, newline.
+func binaryField(name, data string) string {
+	size := make([]byte, 8)
+	binary.LittleEndian.PutUint64(size, uint64(len(data)))
+	return name + "\n" + string(size) + data + "\n"
+}
+
+func TestReadJournalExport(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []journalEntry
+		wantErr bool
+	}{
+		{
+			name: "text fields",
+			input: "__CURSOR=s=1;i=1\n__REALTIME_TIMESTAMP=1700000000000000\n_SYSTEMD_UNIT=ssh.service\n" +
+				"SYSLOG_IDENTIFIER=sshd\nMESSAGE=Failed password for root from 203.0.113.5 port 22 ssh2\n\n",
+			want: []journalEntry{{
+				Cursor:  "s=1;i=1",
+				Time:    time.UnixMicro(1700000000000000),
+				Unit:    "ssh.service",
+				Ident:   "sshd",
+				Message: "Failed password for root from 203.0.113.5 port 22 ssh2",
+			}},
+		},
+		{
+			name: "several entries, last without blank line",
+			input: "__CURSOR=a\nMESSAGE=one\n\n" +
+				"__CURSOR=b\nMESSAGE=two=2\n",
+			want: []journalEntry{
+				{Cursor: "a", Message: "one"},
+				{Cursor: "b", Message: "two=2"},
+			},
+		},
+		{
+			name:  "binary field",
+			input: "__CURSOR=c\n" + binaryField("MESSAGE", "line one\nline two\x00") + "SYSLOG_IDENTIFIER=vsftpd\n\n",
+			want: []journalEntry{
+				{Cursor: "c", Ident: "vsftpd", Message: "line one\nline two\x00"},
+			},
+		},
+		{
+			name:  "blank lines between entries",
+			input: "\n\n__CURSOR=d\nMESSAGE=x\n\n\n",
+			want:  []journalEntry{{Cursor: "d", Message: "x"}},
+		},
+		{
+			name:    "truncated binary size",
+			input:   "__CURSOR=e\nMESSAGE\n\x05\x00",
+			wantErr: true,
+		},
+		{
+			name:    "truncated binary data",
+			input:   "__CURSOR=e\n" + binaryField("MESSAGE", "abcdef")[:len("MESSAGE\n")+8+2],
+			wantErr: true,
+		},
+		{
+			name:    "oversized binary field",
+			input:   "MESSAGE\n\xff\xff\xff\xff\xff\xff\xff\x00",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []journalEntry
+			err := readJournalExport(strings.NewReader(tt.input), func(e journalEntry) {
+				got = append(got, e)
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("entries = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				g, w := got[i], tt.want[i]
+				if g.Cursor != w.Cursor || !g.Time.Equal(w.Time) || g.Unit != w.Unit || g.Ident != w.Ident || g.Message != w.Message {
+					t.Errorf("entry %d = %+v, want %+v", i, g, w)
+				}
+			}
+		})
+	}
+}
+
+func TestJournalValue(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"string", `"Accepted publickey"`, "Accepted publickey"},
+		{"escaped string", `"aé\n"`, "aé\n"},
+		{"bytes", `[104, 105, 255]`, "hi\xff"},
+		{"repeated field", `["first", "second"]`, "first"},
+		{"empty array", `[]`, ""},
+		{"null", `null`, ""},
+		{"number", `42`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := journalValue(json.RawMessage(tt.raw)); got != tt.want {
+				t.Errorf("journalValue(%s) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package monitor
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"securemonitor/internal/config"
+	"securemonitor/internal/firewall"
+	"securemonitor/internal/storage"
+)
+
+// responses a service can take against an offender.
+const (
+	ActionAlert = "alert" // only logs and alerts
+	ActionLimit = "limit" // rate-limits new connections
+	ActionBlock = "block" // denies traffic
+)
+
+// returns the action configured for the service in service_actions, or
+// fallback when there is none (or it is not recognised).
+func serviceAction(cfg config.Config, service, fallback string) string {
+	switch action := strings.ToLower(strings.TrimSpace(cfg.ServiceActions[service])); action {
+	case ActionAlert, ActionLimit, ActionBlock:
+		return action
+	default:
+		return fallback
+	}
+}
+
+// applies the service's response to an offender that crossed its
+// threshold. Alert-only services stop here: the alert is already stored.
+func (eng *Engine) enforce(cfg config.Config, service, action string, ports []string, ip, reason string, now time.Time) {
+	switch action {
+	case ActionLimit:
+		eng.limitIP(cfg, service, ports, ip, reason, now)
+	case ActionBlock:
+		eng.blockIP(cfg, service, ports, ip, reason, now)
+	}
+}
+
+// returns how long a rate limit lasts.
+func limitDuration(cfg config.Config) time.Duration {
+	if cfg.LimitMinutes > 0 {
+		return time.Duration(cfg.LimitMinutes) * time.Minute
+	}
+	return 30 * time.Minute
+}
+
+// returns how many offences a limited source may commit before it is
+// blocked.
+func limitEscalateAfter(cfg config.Config) int {
+	if cfg.LimitEscalateAfter > 0 {
+		return cfg.LimitEscalateAfter
+	}
+	return 3
+}
+
+// rate-limits an offender, or counts another offence when it is already
+// limited and escalates it to a full block once it keeps offending.
+// Sources that are already blocked are left alone.
+func (eng *Engine) limitIP(cfg config.Config, service string, ports []string, ip, reason string, now time.Time) {
+	prefix := logPrefix(service)
+	ports = scopedPorts(cfg, ports)
+
+	if _, ok := eng.store.GetBlocked(ip); ok {
+		return
+	}
+	if _, ok := coveringPrefix(ip, eng.store.ListBlockedEntries()); ok {
+		return
+	}
+
+	if cfg.IsDryRun(service) {
+		eng.noteWouldLimit(cfg, service, ip, reason, now)
+		return
+	}
+
+	existing, limited := eng.store.GetLimited(ip)
+	if limited && existing.State == storage.BlockRemoving {
+		// lifting in progress (expiry): start over as a new limit.
+		limited = false
+	}
+
+	if limited {
+		entry := eng.store.AddLimited(storage.BlockedEntry{IP: ip, Service: service, Ports: ports})
+		escalateAfter := limitEscalateAfter(cfg)
+
+		if entry.Strikes > escalateAfter {
+			eng.store.Log(storage.LogEntry{
+				Level:     storage.LevelWarn,
+				Component: service,
+				Event:     "limit_escalated",
+				IP:        ip,
+				Host:      eng.eventHost(service, ip),
+				Fields:    storage.Fields{"reason": reason, "offences": entry.Strikes - 1},
+				Message: fmt.Sprintf(
+					"%s Escalating %s from rate limit to block (%s, offences while limited=%d)",
+					prefix, ip, reason, entry.Strikes-1,
+				),
+			})
+			eng.submitUnlimit(entry)
+			eng.blockIP(cfg, service, ports, ip, reason+", escalated from rate limit", now)
+			return
+		}
+
+		eng.store.Log(storage.LogEntry{
+			Component: service,
+			Event:     "limit_offence",
+			IP:        ip,
+			Host:      eng.eventHost(service, ip),
+			Fields:    storage.Fields{"reason": reason, "offences": entry.Strikes - 1, "escalate_after": escalateAfter},
+			Message: fmt.Sprintf(
+				"%s %s still offending while rate-limited (%s, offence %d/%d)",
+				prefix, ip, reason, entry.Strikes-1, escalateAfter,
+			),
+		})
+		if missing, more := missingPorts(existing.Ports, ports); more {
+			eng.submitLimit(firewall.Rule{
+				IP:      ip,
+				Timeout: limitDuration(cfg) - now.Sub(existing.BlockedAt),
+				Ports:   missing,
+				Service: service,
+				Strikes: entry.Strikes,
+			})
+		}
+		return
+	}
+
+	eng.store.RemoveLimited(ip)
+	entry := eng.store.AddLimited(storage.BlockedEntry{IP: ip, Service: service, Ports: ports, State: storage.BlockPending})
+
+	eng.store.Log(storage.LogEntry{
+		Level:     storage.LevelWarn,
+		Component: service,
+		Event:     "limit",
+		IP:        ip,
+		Host:      eng.eventHost(service, ip),
+		Fields:    storage.Fields{"reason": reason, "scope": describeScope(ports), "duration": limitDuration(cfg).String()},
+		Message: fmt.Sprintf(
+			"%s Rate-limiting %s (%s, scope=%s, for %s)",
+			prefix, ip, reason, describeScope(ports), limitDuration(cfg),
+		),
+	})
+	eng.submitLimit(firewall.Rule{
+		IP:      ip,
+		Timeout: limitDuration(cfg),
+		Ports:   ports,
+		Service: service,
+		Strikes: entry.Strikes,
+	})
+
+	eng.store.AddAlert(storage.Alert{
+		Timestamp: now.Format(time.RFC3339),
+		Service:   service,
+		IP:        ip,
+		Host:      eng.eventHost(service, ip),
+		Country:   lookupCountry(ip),
+		Severity:  "MEDIUM",
+		Message:   fmt.Sprintf("Rate-limited %s (%s)", ip, reason),
+	})
+}
+
+// records, once per limit period, that the source would have been
+// rate-limited.
+func (eng *Engine) noteWouldLimit(cfg config.Config, service, ip, reason string, now time.Time) {
+	eng.wouldLimitMu.Lock()
+	defer eng.wouldLimitMu.Unlock()
+
+	if at, ok := eng.wouldLimitNoted[ip]; ok && now.Sub(at) < limitDuration(cfg) {
+		return
+	}
+	eng.wouldLimitNoted[ip] = now
+
+	eng.store.Log(storage.LogEntry{
+		Component: service,
+		Event:     "would_limit",
+		IP:        ip,
+		Host:      eng.eventHost(service, ip),
+		Fields:    storage.Fields{"reason": reason, "duration": limitDuration(cfg).String()},
+		Message: fmt.Sprintf(
+			"%s [DRY-RUN] Would rate-limit %s (%s, for %s)",
+			logPrefix(service), ip, reason, limitDuration(cfg),
+		),
+	})
+	eng.store.AddAlert(storage.Alert{
+		Timestamp: now.Format(time.RFC3339),
+		Service:   service,
+		IP:        ip,
+		Host:      eng.eventHost(service, ip),
+		Country:   lookupCountry(ip),
+		Severity:  "MEDIUM",
+		Message:   fmt.Sprintf("Would rate-limit %s (%s)", ip, reason),
+		DryRun:    true,
+	})
+}
+
+// lifts every rate limit older than limit_minutes. Limits of services in
+// dry-run mode are left in place, the firewall is frozen for them.
+func (eng *Engine) expireLimits(cfg config.Config, now time.Time) {
+	maxAge := limitDuration(cfg)
+
+	for _, e := range eng.store.ListLimited() {
+		if e.State == storage.BlockPending || e.State == storage.BlockRemoving {
+			continue
+		}
+		age := now.Sub(e.BlockedAt)
+		if age < maxAge || cfg.IsDryRun(e.Service) {
+			continue
+		}
+
+		eng.store.Log(storage.LogEntry{
+			Component: "firewall",
+			Event:     "unlimit",
+			IP:        e.IP,
+			Fields:    storage.Fields{"age": age.Truncate(time.Second).String(), "offences": e.Strikes},
+			Message: fmt.Sprintf(
+				"[FW] Rate limit on %s expired (age=%s, offences=%d)",
+				e.IP, age.Truncate(time.Second), e.Strikes,
+			),
+		})
+		eng.submitUnlimit(e)
+	}
+}
@@ -0,0 +1,62 @@
+package monitor
+
+import (
+	"bufio"
+	"io"
+)
+
+const (
+	lineBufferSize = 64 << 10 // bufio buffer per followed file
+	maxLineSize    = 1 << 20  // longer lines are cut into pieces of this size
+)
+
+// lineReader reads complete lines from a file that is still being
+// written. A trailing line without its newline yet is held back until
+// the rest arrives.
+type lineReader struct {
+	r       *bufio.Reader
+	partial []byte
+}
+
+func newLineReader(r io.Reader) *lineReader {
+	return &lineReader{r: bufio.NewReaderSize(r, lineBufferSize)}
+}
+
+// returns the complete lines read until the end of the input or until
+// budget bytes were consumed, and the number of bytes they took. eof
+// reports whether the end was reached. With final set, a trailing line
+// without newline is returned too: nothing more will be written.
+func (lr *lineReader) next(budget int64, final bool) (lines []string, n int64, eof bool) {
+	for n < budget {
+		chunk, err := lr.r.ReadSlice('\n')
+		lr.partial = append(lr.partial, chunk...)
+
+		switch {
+		case err == nil:
+			lines = append(lines, string(lr.partial[:len(lr.partial)-1]))
+			n += int64(len(lr.partial))
+			lr.partial = lr.partial[:0]
+
+		case err == bufio.ErrBufferFull:
+			if len(lr.partial) >= maxLineSize {
+				lines = append(lines, string(lr.partial))
+				n += int64(len(lr.partial))
+				lr.partial = lr.partial[:0]
+			}
+
+		default: // io.EOF, or a read error ending this read
+			if final && len(lr.partial) > 0 {
+				lines = append(lines, string(lr.partial))
+				n += int64(len(lr.partial))
+				lr.partial = lr.partial[:0]
+			}
+			return lines, n, true
+		}
+	}
+	return lines, n, false
+}
+
+// returns the size of the line held back.
+func (lr *lineReader) held() int {
+	return len(lr.partial)
+}
@@ -0,0 +1,89 @@
+package monitor
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// growingFile hands out what was written so far and then io.EOF, like a
+// log file that is still being appended to.
+type growingFile struct {
+	bytes.Buffer
+}
+
+func TestLineReader(t *testing.T) {
+	type step struct {
+		write  string
+		budget int64
+		final  bool
+
+		lines []string
+		n     int64
+		eof   bool
+		held  int
+	}
+
+	long := strings.Repeat("x", maxLineSize+10)
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"complete lines", []step{
+			{write: "a\nbb\n", budget: 1 << 20, lines: []string{"a", "bb"}, n: 5, eof: true},
+		}},
+		{"empty lines", []step{
+			{write: "\n\n", budget: 1 << 20, lines: []string{"", ""}, n: 2, eof: true},
+		}},
+		{"partial line held until complete", []step{
+			{write: "a\nhal", budget: 1 << 20, lines: []string{"a"}, n: 2, eof: true, held: 3},
+			{write: "", budget: 1 << 20, n: 0, eof: true, held: 3},
+			{write: "f\nb", budget: 1 << 20, lines: []string{"half"}, n: 5, eof: true, held: 1},
+		}},
+		{"partial line returned when final", []step{
+			{write: "x\ntail", budget: 1 << 20, final: true, lines: []string{"x", "tail"}, n: 6, eof: true},
+		}},
+		{"budget stops after the line crossing it", []step{
+			{write: "aaaa\nbbbb\ncccc\n", budget: 6, lines: []string{"aaaa", "bbbb"}, n: 10},
+			{budget: 1 << 20, lines: []string{"cccc"}, n: 5, eof: true},
+		}},
+		{"long line cut into pieces", []step{
+			{write: long + "\n", budget: 4 << 20, lines: []string{long[:maxLineSize], long[maxLineSize:]}, n: int64(len(long)) + 1, eof: true},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &growingFile{}
+			lr := newLineReader(f)
+
+			for i, st := range tt.steps {
+				f.WriteString(st.write)
+				lines, n, eof := lr.next(st.budget, st.final)
+
+				if strings.Join(lines, "|") != strings.Join(st.lines, "|") || len(lines) != len(st.lines) {
+					t.Errorf("step %d: lines = %q, want %q", i, shorten(lines), shorten(st.lines))
+				}
+				if n != st.n || eof != st.eof {
+					t.Errorf("step %d: n, eof = %d, %v, want %d, %v", i, n, eof, st.n, st.eof)
+				}
+				if lr.held() != st.held {
+					t.Errorf("step %d: held = %d, want %d", i, lr.held(), st.held)
+				}
+			}
+		})
+	}
+}
+
+// keeps failure messages readable when lines are huge.
+func shorten(lines []string) []string {
+	out := make([]string, len(lines))
+	for i, l := range lines {
+		if len(l) > 40 {
+			l = l[:20] + "..." + l[len(l)-20:]
+		}
+		out[i] = l
+	}
+	return out
+}
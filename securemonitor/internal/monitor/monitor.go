@@ -4,19 +4,22 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"net"
 	"net/http"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"securemonitor/internal/config"
-	"securemonitor/internal/firewall"
+	"securemonitor/internal/ipaddr"
 	"securemonitor/internal/storage"
 )
 
 
 // attempts to parse a client IP address from a log line
 // uses known patterns (Apache client, rhost=, " from ").
+// The result is canonical (net/netip form); tokens that are not an IP
+// address (hostnames, garbage) yield "" and are never counted.
 func extractIP(line string) string {
 	// Apache client pattern.
 	if strings.Contains(line, "Client \"") {
@@ -24,7 +27,7 @@ func extractIP(line string) string {
 		if len(parts) > 1 {
 			rest := parts[1]
 			ip := strings.SplitN(rest, "\"", 2)[0]
-			return strings.TrimSpace(ip)
+			return ipaddr.Canonical(ip)
 		}
 	}
 
@@ -32,8 +35,9 @@ func extractIP(line string) string {
 	if strings.Contains(line, "rhost=") {
 		parts := strings.Split(line, "rhost=")
 		if len(parts) > 1 {
-			ip := strings.Fields(parts[1])[0]
-			return strings.TrimSpace(ip)
+			if fields := strings.Fields(parts[1]); len(fields) > 0 {
+				return ipaddr.Canonical(fields[0])
+			}
 		}
 	}
 
@@ -41,8 +45,9 @@ func extractIP(line string) string {
 	if strings.Contains(line, " from ") {
 		parts := strings.Split(line, " from ")
 		if len(parts) > 1 {
-			ip := strings.Fields(parts[1])[0]
-			return strings.TrimSpace(ip)
+			if fields := strings.Fields(parts[1]); len(fields) > 0 {
+				return ipaddr.Canonical(fields[0])
+			}
 		}
 	}
 
@@ -74,6 +79,7 @@ func classifySeverity(service string, newEvents, total, threshold int) string {
 //  GEO IP (country) via ip-api.com
 var (
 	httpClient = &http.Client{Timeout: 2 * time.Second}
+	geoMu      sync.Mutex
 	geoCache   = make(map[string]string)
 )
 
@@ -84,6 +90,13 @@ type ipAPIResponse struct {
 	CountryCode string `json:"countryCode"`
 }
 
+// remembers the country label of an IP.
+func cacheCountry(ip, label string) {
+	geoMu.Lock()
+	geoCache[ip] = label
+	geoMu.Unlock()
+}
+
 // lookupCountry resolves the country label for an IP:
 // - local/private IPs -> "Local"
 // - failures -> empty string.
@@ -93,12 +106,19 @@ func lookupCountry(ip string) string {
 		return ""
 	}
 
-	parsed := net.ParseIP(ip)
-	if parsed != nil && (parsed.IsLoopback() || parsed.IsPrivate()) {
+	addr, ok := ipaddr.Parse(ip)
+	if !ok {
+		return ""
+	}
+	if ipaddr.IsLocal(addr) {
 		return "Local"
 	}
+	ip = addr.String()
 
-	if c, ok := geoCache[ip]; ok {
+	geoMu.Lock()
+	c, cached := geoCache[ip]
+	geoMu.Unlock()
+	if cached {
 		return c
 	}
 
@@ -106,7 +126,7 @@ func lookupCountry(ip string) string {
 	resp, err := httpClient.Get(url)
 	if err != nil {
 		log.Printf("geo: lookup failed for %s: %v", ip, err)
-		geoCache[ip] = ""
+		cacheCountry(ip, "")
 		return ""
 	}
 	defer resp.Body.Close()
@@ -114,12 +134,12 @@ func lookupCountry(ip string) string {
 	var data ipAPIResponse
 	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
 		log.Printf("geo: decode failed for %s: %v", ip, err)
-		geoCache[ip] = ""
+		cacheCountry(ip, "")
 		return ""
 	}
 
 	if data.Status != "success" {
-		geoCache[ip] = ""
+		cacheCountry(ip, "")
 		return ""
 	}
 
@@ -127,12 +147,13 @@ func lookupCountry(ip string) string {
 	if data.CountryCode != "" {
 		label += " (" + data.CountryCode + ")"
 	}
-	geoCache[ip] = label
+	cacheCountry(ip, label)
 	return label
 }
 
 
-// Makes autoblock incremental.
+// returns how long a block with the given strike count lasts,
+// which makes autoblock incremental.
 //
 // Base: cfg.AutoUnblockMinutes (ej. 5 min)
 // Strikes:
@@ -141,34 +162,80 @@ func lookupCountry(ip string) string {
 //   3er strike  -> 5x base   (25 min)
 //   4to strike  -> 7x base   (35 min)
 //   ...
-func autoUnblockExpired(cfg config.Config, now time.Time) {
+//
+// Returns 0 when auto-unblock is disabled (block until manual unblock).
+func banDuration(cfg config.Config, strikes int) time.Duration {
 	if cfg.AutoUnblockMinutes <= 0 {
-		return
+		return 0
+	}
+	if strikes <= 0 {
+		strikes = 1
 	}
 
 	base := time.Duration(cfg.AutoUnblockMinutes) * time.Minute
-	entries := storage.ListBlockedEntries()
+	factor := 1 + (strikes-1)*2
+	return base * time.Duration(factor)
+}
+
+// returns when the block lifts: its stored expiry or, for entries
+// without one, BlockedAt plus the ban for its strikes. Zero means never.
+func blockExpiry(cfg config.Config, e storage.BlockedEntry) time.Time {
+	if !e.ExpiresAt.IsZero() {
+		return e.ExpiresAt
+	}
+	ban := banDuration(cfg, e.Strikes)
+	if ban <= 0 {
+		return time.Time{}
+	}
+	return e.BlockedAt.Add(ban)
+}
+
+// lifts every block past its expiry.
+func (eng *Engine) autoUnblockExpired(cfg config.Config, now time.Time) {
+	if cfg.AutoUnblockMinutes <= 0 {
+		return
+	}
+
+	eng.expireShadowBlocks(cfg, now)
+	entries := eng.store.ListBlockedEntries()
 
 	for _, e := range entries {
+		// firewall change already in flight.
+		if e.State == storage.BlockPending || e.State == storage.BlockRemoving {
+			continue
+		}
+
 		strikes := e.Strikes
 		if strikes <= 0 {
 			strikes = 1
 		}
 
-		factor := 1 + (strikes-1)*2
-		maxAge := base * time.Duration(factor)
+		expiry := blockExpiry(cfg, e)
+		if expiry.IsZero() {
+			continue
+		}
+		maxAge := expiry.Sub(e.BlockedAt)
 
 		age := now.Sub(e.BlockedAt)
-		if age >= maxAge {
-			storage.AddLog(fmt.Sprintf(
-				"[FW] Auto-unblock %s (age=%s, strikes=%d, maxAge≈%s)",
-				e.IP,
-				age.Truncate(time.Second),
-				strikes,
-				maxAge.Truncate(time.Second),
-			))
-			firewall.UnblockIP(e.IP)
-			storage.RemoveBlocked(e.IP)
+		if !now.Before(expiry) {
+			if cfg.IsDryRun(e.Service) {
+				eng.noteWouldUnblock(e, age, now)
+				continue
+			}
+			eng.store.Log(storage.LogEntry{
+				Component: "firewall",
+				Event:     "unblock",
+				IP:        e.IP,
+				Fields:    storage.Fields{"age": age.Truncate(time.Second).String(), "strikes": strikes},
+				Message: fmt.Sprintf(
+					"[FW] Auto-unblock %s (age=%s, strikes=%d, maxAge≈%s)",
+					e.IP,
+					age.Truncate(time.Second),
+					strikes,
+					maxAge.Truncate(time.Second),
+				),
+			})
+			eng.submitUnblock(e)
 		}
 	}
 }
@@ -189,24 +256,19 @@ func effectiveThreshold(specific, global, fallback int) int {
 
 
 
-// reads new SSH/FTP failures and injects simulated events,
-// taking into account the case where both services share the same log file.
-func readSSHAndFTP(cfg config.Config) (map[string]int, map[string]int) {
-	var sshFails map[string]int
-	var ftpFails map[string]int
-
-	if cfg.SSHLogPath == cfg.FTPLogPath {
-		lines, err := ReadNewLines(cfg.SSHLogPath)
-		if err != nil {
-			lines = []string{}
-		}
-		sshFails = parseSSHFailuresFromLines(lines)
-		ftpFails = parseFTPFailuresFromLines(lines)
-	} else {
-		sshFails = parseSSHFailures(cfg.SSHLogPath)
-		ftpFails = parseFTPFailures(cfg.FTPLogPath)
+// reads new SSH/FTP failures from the logs, the journal and syslog and
+// injects simulated events, taking into account the case where both
+// services share the same log file.
+func (eng *Engine) readSSHAndFTP(cfg config.Config) (map[string]int, map[string]int) {
+	sshLines := eng.readLogLines(cfg.SSHLogPath)
+	ftpLines := sshLines
+	if cfg.FTPLogPath != cfg.SSHLogPath {
+		ftpLines = eng.readLogLines(cfg.FTPLogPath)
 	}
 
+	sshFails := eng.parseSSHFailuresFromLines(slices.Concat(sshLines, eng.drainJournal("ssh"), eng.drainSyslog("ssh")))
+	ftpFails := eng.parseFTPFailuresFromLines(slices.Concat(ftpLines, eng.drainJournal("ftp"), eng.drainSyslog("ftp")))
+
 	// Inject simulated events.
 	for ip, c := range drainSimulatedSSH() {
 		sshFails[ip] += c
@@ -218,9 +280,11 @@ func readSSHAndFTP(cfg config.Config) (map[string]int, map[string]int) {
 	return sshFails, ftpFails
 }
 
-// reads new Apache errors and injects simulated events.
-func readApache(cfg config.Config) map[string]int {
-	apacheErrors := parseApacheErrors(cfg.ApacheAccessLogPath)
+// reads new Apache errors from the access log, the journal and syslog
+// and injects simulated events.
+func (eng *Engine) readApache(cfg config.Config) map[string]int {
+	lines := slices.Concat(eng.readLogLines(cfg.ApacheAccessLogPath), eng.drainJournal("apache"), eng.drainSyslog("apache"))
+	apacheErrors := eng.parseApacheErrorsFromLines(lines)
 	for ip, c := range drainSimulatedApache() {
 		apacheErrors[ip] += c
 	}
@@ -229,31 +293,66 @@ func readApache(cfg config.Config) map[string]int {
 
 //-----------------IMPORTANT---------------
 
-// RunLoop is the main monitoring loop that periodically scans logs,
+// Run is the main monitoring loop that periodically scans logs,
 // updates stats, generates alerts and enforces firewall blocks.
-func RunLoop(cfg config.Config) {
-	ticker := time.NewTicker(time.Duration(cfg.CheckIntervalSeconds) * time.Second)
-	defer ticker.Stop()
+func (eng *Engine) Run() {
+	cfg := eng.cfg
+	interval := time.Duration(cfg.CheckIntervalSeconds) * time.Second
+
+	// Wake up on log writes; the interval still drives expiry and
+	// reconciliation when the logs are quiet.
+	if err := eng.loadTailState(cfg.TailStatePath()); err != nil {
+		log.Printf("monitor loop: ignoring saved log offsets: %v", err)
+	}
+	eng.startJournal(cfg)
+	eng.startSyslog(cfg)
+
+	watcher := newLogWatcher(eng.wake)
+	defer watcher.Close()
+	for _, path := range []string{cfg.SSHLogPath, cfg.FTPLogPath, cfg.ApacheAccessLogPath} {
+		if path == "" {
+			continue
+		}
+		if err := watcher.Add(path); err != nil {
+			log.Printf("monitor loop: not watching %s, polling it: %v", path, err)
+		}
+	}
 
-	// Service strategies.
-	sshStrategy := NewSSHStrategy()
-	ftpStrategy := NewFTPStrategy()
-	apacheStrategy := NewApacheStrategy()
+	// Reconcile firewall and state once at startup, then periodically.
+	reconcileEvery := time.Duration(cfg.ReconcileIntervalMinutes) * time.Minute
+	eng.enforceMu.Lock()
+	eng.reconcile(cfg, time.Now())
+	eng.enforceMu.Unlock()
+	lastReconcile := time.Now()
 
 	for {
 		now := time.Now()
+		eng.enforceMu.Lock()
+
+		if reconcileEvery > 0 && now.Sub(lastReconcile) >= reconcileEvery {
+			eng.reconcile(cfg, now)
+			lastReconcile = now
+		}
 
 		// Reload whitelist each cycle (small file, cheap enough).
 		whitelist := loadWhitelist(cfg.WhitelistFile)
+		eng.warnInvalidWhitelist(whitelist)
 
 		// Auto-unblock old IPs.
-		autoUnblockExpired(cfg, now)
+		eng.autoUnblockExpired(cfg, now)
+		eng.expireLimits(cfg, now)
 
-		storage.AddLog("[SCAN START] " + now.Format(time.RFC3339))
+		eng.store.Log(storage.LogEntry{
+			Timestamp: now,
+			Component: "scan",
+			Event:     "scan_start",
+			Message:   "[SCAN START] " + now.Format(time.RFC3339),
+		})
 
 		// Read events for SSH/FTP and Apache.
-		sshFails, ftpFails := readSSHAndFTP(cfg)
-		apacheErrors := readApache(cfg)
+		eng.resetEventHosts()
+		sshFails, ftpFails := eng.readSSHAndFTP(cfg)
+		apacheErrors := eng.readApache(cfg)
 
 		log.Printf(
 			"monitor loop: ssh_ips=%d ftp_ips=%d apache_ips=%d",
@@ -263,13 +362,32 @@ func RunLoop(cfg config.Config) {
 		)
 
 		// Delegate per-service logic to strategies.
-		sshStrategy.ProcessEvents(sshFails, cfg, now, whitelist)
-		ftpStrategy.ProcessEvents(ftpFails, cfg, now, whitelist)
-		apacheStrategy.ProcessEvents(apacheErrors, cfg, now, whitelist)
+		eng.ssh.ProcessEvents(sshFails, cfg, now, whitelist)
+		eng.ftp.ProcessEvents(ftpFails, cfg, now, whitelist)
+		eng.apache.ProcessEvents(apacheErrors, cfg, now, whitelist)
 
-		// Persist blocked snapshot to disk.
-		storage.SaveBlockedToFile(cfg.BlockedIPsFile)
+		// Promote clusters of offenders to subnet blocks.
+		eng.aggregateSubnets(cfg, now, whitelist)
 
-		<-ticker.C
+		// Persist blocks and strikes to disk.
+		if err := eng.store.Save(); err != nil {
+			log.Printf("monitor loop: saving block database: %v", err)
+		}
+		if err := eng.saveTailState(cfg.TailStatePath()); err != nil {
+			log.Printf("monitor loop: saving log offsets: %v", err)
+		}
+		if err := eng.saveJournalCursors(cfg.JournalCursorPath()); err != nil {
+			log.Printf("monitor loop: saving journal cursors: %v", err)
+		}
+
+		eng.enforceMu.Unlock()
+
+		// Keep reading without waiting while a log is behind.
+		if !eng.tailBehind() {
+			watcher.Wait(interval)
+		}
+		if gap := time.Until(now.Add(minScanGap)); gap > 0 {
+			time.Sleep(gap)
+		}
 	}
 }
@@ -0,0 +1,391 @@
+package monitor
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"securemonitor/internal/config"
+	"securemonitor/internal/firewall"
+	"securemonitor/internal/ipaddr"
+	"securemonitor/internal/storage"
+)
+
+// DriftReport describes the differences found between the blocked state
+// kept by SecureMonitor and the rules actually present in the firewall.
+type DriftReport struct {
+	CheckedAt time.Time `json:"checked_at"`
+	Backend   string    `json:"backend"`
+
+	// rules tagged as ours that no blocked entry accounts for.
+	RulesWithoutState []string `json:"rules_without_state"`
+	// blocked entries whose rule is missing from the firewall.
+	StateWithoutRules []string `json:"state_without_rules"`
+	// blocked entries whose rules cover other ports than the entry.
+	PortMismatch []string `json:"port_mismatch"`
+	// untagged rules of blocked entries replaced by tagged ones.
+	Migrated []string `json:"migrated,omitempty"`
+	// blocked entries that had already expired and were dropped instead.
+	Expired []string `json:"expired"`
+
+	// the same for rate limits: limit rules no limit accounts for, and
+	// limits whose rule is missing. Limits with rules for other ports are
+	// listed under PortMismatch.
+	LimitRulesWithoutState []string `json:"limit_rules_without_state"`
+	LimitsWithoutRules     []string `json:"limits_without_rules"`
+
+	Repaired int      `json:"repaired"` // repairs queued
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// returns the most recent drift report (zero value before the first run).
+func (eng *Engine) LastDrift() DriftReport {
+	eng.driftMu.Lock()
+	defer eng.driftMu.Unlock()
+	return eng.lastDrift
+}
+
+// reconciles immediately with the configuration of the engine.
+func (eng *Engine) ReconcileNow() DriftReport {
+	eng.enforceMu.Lock()
+	defer eng.enforceMu.Unlock()
+	return eng.reconcile(eng.cfg, time.Now())
+}
+
+// canonicalizes a rule target so state and firewall listings compare
+// equal: host prefixes some backends print (203.0.113.7/32) become plain
+// addresses and CIDR blocks are masked.
+func normalizeRuleIP(ip string) string {
+	if target := ipaddr.CanonicalTarget(ip); target != "" {
+		return target
+	}
+	return strings.TrimSpace(ip)
+}
+
+// compares the live firewall rules with the blocked state and repairs
+// drift in both directions. Callers must hold enforceMu.
+func (eng *Engine) reconcile(cfg config.Config, now time.Time) DriftReport {
+	report := DriftReport{
+		CheckedAt: now,
+		Backend:   firewall.Active().Name(),
+	}
+
+	live, err := firewall.List()
+	if err != nil {
+		report.Errors = append(report.Errors, "list rules: "+err.Error())
+		eng.storeDrift(report)
+		return report
+	}
+
+	rules := make(map[string]firewall.Rule, len(live))
+	for _, r := range live {
+		rules[normalizeRuleIP(r.IP)] = r
+	}
+
+	state := make(map[string]storage.BlockedEntry)
+	for _, e := range eng.store.ListBlockedEntries() {
+		state[normalizeRuleIP(e.IP)] = e
+	}
+
+	if !eng.legacyChecked {
+		eng.legacyChecked = true
+		eng.migrateLegacyRules(cfg, state, &report)
+	}
+
+	// State without rules, or with rules for other ports: re-apply what
+	// the entry asks for, for the remaining ban time, and drop the rules
+	// it does not account for; or drop the entry when the ban would
+	// already have been lifted. Services in dry-run mode only get
+	// reported.
+	for ip, e := range state {
+		r, ok := rules[ip]
+		if ok && samePorts(e.Ports, r.Ports) {
+			continue
+		}
+		// firewall change still in flight: not drift.
+		if e.State == storage.BlockPending || e.State == storage.BlockRemoving {
+			continue
+		}
+		if cfg.IsDryRun(e.Service) {
+			if ok {
+				report.PortMismatch = append(report.PortMismatch, e.IP)
+			} else {
+				report.StateWithoutRules = append(report.StateWithoutRules, e.IP)
+			}
+			continue
+		}
+
+		var remaining time.Duration
+		if expiry := blockExpiry(cfg, e); !expiry.IsZero() {
+			remaining = expiry.Sub(now)
+			if remaining <= 0 {
+				report.Expired = append(report.Expired, e.IP)
+				eng.store.RemoveBlocked(e.IP)
+				if ok {
+					eng.submitRule("unblock", r)
+				}
+				continue
+			}
+		}
+
+		add := firewall.Rule{
+			IP:      e.IP,
+			Timeout: remaining,
+			Ports:   e.Ports,
+			Service: e.Service,
+			Strikes: e.Strikes,
+		}
+		if !ok {
+			report.StateWithoutRules = append(report.StateWithoutRules, e.IP)
+		} else {
+			report.PortMismatch = append(report.PortMismatch, e.IP)
+			var extra []string
+			switch {
+			case len(e.Ports) == 0:
+				// host-wide entry, only port rules live: they go.
+				extra = r.Ports
+			case len(r.Ports) == 0:
+				// scoped entry, host-wide rule live: it goes.
+				eng.submitRule("unblock", firewall.Rule{IP: r.IP, Service: e.Service})
+			default:
+				add.Ports = portsNotIn(e.Ports, r.Ports)
+				extra = portsNotIn(r.Ports, e.Ports)
+			}
+			if len(extra) > 0 {
+				eng.submitRule("unblock", firewall.Rule{IP: r.IP, Ports: extra, Service: e.Service})
+			}
+		}
+
+		if len(e.Ports) == 0 || len(add.Ports) > 0 {
+			eng.store.SetBlockState(e.IP, storage.BlockPending)
+			eng.submitBlock(add)
+		}
+		report.Repaired++
+	}
+
+	// Rules without state: remove them, they are ours but nothing
+	// would ever lift them.
+	for ip, r := range rules {
+		if _, ok := state[ip]; ok {
+			continue
+		}
+
+		report.RulesWithoutState = append(report.RulesWithoutState, ip)
+		if cfg.DryRun {
+			continue
+		}
+		eng.submitRule("unblock", r)
+		report.Repaired++
+	}
+
+	eng.reconcileLimits(cfg, now, &report)
+
+	limitDrift := len(report.LimitRulesWithoutState) + len(report.LimitsWithoutRules)
+	if len(report.RulesWithoutState) > 0 || len(report.StateWithoutRules) > 0 || len(report.PortMismatch) > 0 || len(report.Expired) > 0 || limitDrift > 0 {
+		eng.store.Log(storage.LogEntry{
+			Level:     storage.LevelWarn,
+			Component: "firewall",
+			Event:     "reconcile",
+			Fields: storage.Fields{
+				"backend":                   report.Backend,
+				"rules_without_state":       len(report.RulesWithoutState),
+				"state_without_rules":       len(report.StateWithoutRules),
+				"port_mismatch":             len(report.PortMismatch),
+				"migrated":                  len(report.Migrated),
+				"expired":                   len(report.Expired),
+				"limit_rules_without_state": len(report.LimitRulesWithoutState),
+				"limits_without_rules":      len(report.LimitsWithoutRules),
+				"repaired":                  report.Repaired,
+			},
+			Message: fmt.Sprintf(
+				"[FW] Reconciled %s: %d rules without state, %d entries without rules, %d with other ports, %d expired, %d limit rules without state, %d limits without rules, %d repaired",
+				report.Backend,
+				len(report.RulesWithoutState),
+				len(report.StateWithoutRules),
+				len(report.PortMismatch),
+				len(report.Expired),
+				len(report.LimitRulesWithoutState),
+				len(report.LimitsWithoutRules),
+				report.Repaired,
+			),
+		})
+	}
+	for _, e := range report.Errors {
+		log.Printf("reconcile: %s", e)
+	}
+
+	eng.storeDrift(report)
+	return report
+}
+
+// compares the live rate-limit rules with the stored limits the way
+// reconcile does for blocks. Limits past limit_minutes are left to
+// expireLimits, which lifts them with their rules.
+func (eng *Engine) reconcileLimits(cfg config.Config, now time.Time, report *DriftReport) {
+	live, ok, err := firewall.ListLimits()
+	if !ok {
+		return
+	}
+	if err != nil {
+		report.Errors = append(report.Errors, "list limits: "+err.Error())
+		return
+	}
+
+	rules := make(map[string]firewall.Rule, len(live))
+	for _, r := range live {
+		rules[normalizeRuleIP(r.IP)] = r
+	}
+	state := make(map[string]storage.BlockedEntry)
+	for _, e := range eng.store.ListLimited() {
+		state[normalizeRuleIP(e.IP)] = e
+	}
+
+	for ip, e := range state {
+		r, ok := rules[ip]
+		if ok && samePorts(e.Ports, r.Ports) {
+			continue
+		}
+		if e.State == storage.BlockPending || e.State == storage.BlockRemoving {
+			continue
+		}
+		remaining := limitDuration(cfg) - now.Sub(e.BlockedAt)
+		if remaining <= 0 {
+			continue
+		}
+		if ok {
+			report.PortMismatch = append(report.PortMismatch, e.IP)
+		} else {
+			report.LimitsWithoutRules = append(report.LimitsWithoutRules, e.IP)
+		}
+		if cfg.IsDryRun(e.Service) {
+			continue
+		}
+
+		add := firewall.Rule{IP: e.IP, Timeout: remaining, Ports: e.Ports, Service: e.Service, Strikes: e.Strikes}
+		if ok {
+			var extra []string
+			switch {
+			case len(e.Ports) == 0:
+				extra = r.Ports
+			case len(r.Ports) == 0:
+				eng.submitRule("unlimit", firewall.Rule{IP: r.IP, Service: e.Service})
+			default:
+				add.Ports = portsNotIn(e.Ports, r.Ports)
+				extra = portsNotIn(r.Ports, e.Ports)
+			}
+			if len(extra) > 0 {
+				eng.submitRule("unlimit", firewall.Rule{IP: r.IP, Ports: extra, Service: e.Service})
+			}
+		}
+
+		if len(e.Ports) == 0 || len(add.Ports) > 0 {
+			eng.store.SetLimitState(e.IP, storage.BlockPending)
+			eng.submitLimit(add)
+		}
+		report.Repaired++
+	}
+
+	for ip, r := range rules {
+		if _, ok := state[ip]; ok {
+			continue
+		}
+
+		report.LimitRulesWithoutState = append(report.LimitRulesWithoutState, ip)
+		if cfg.DryRun {
+			continue
+		}
+		eng.submitRule("unlimit", r)
+		report.Repaired++
+	}
+}
+
+// replaces the untagged rules of blocked entries (left by versions that
+// did not tag their rules) so the entries are re-applied with tagged ones
+// by the caller. Untagged rules of unknown targets are the operator's and
+// stay.
+func (eng *Engine) migrateLegacyRules(cfg config.Config, state map[string]storage.BlockedEntry, report *DriftReport) {
+	legacy, err := firewall.ListUntagged()
+	if err != nil {
+		report.Errors = append(report.Errors, "list untagged rules: "+err.Error())
+		return
+	}
+
+	for _, r := range legacy {
+		e, ok := state[normalizeRuleIP(r.IP)]
+		if !ok || cfg.IsDryRun(e.Service) {
+			continue
+		}
+		report.Migrated = append(report.Migrated, e.IP)
+		eng.submitRule("unblock", r)
+	}
+}
+
+// reports whether two port lists hold the same ports; empty means
+// host-wide.
+func samePorts(a, b []string) bool {
+	return len(portsNotIn(a, b)) == 0 && len(portsNotIn(b, a)) == 0 && (len(a) == 0) == (len(b) == 0)
+}
+
+// returns the ports of a that b does not cover, comparing port by port:
+// ipset lists a range such as 40000:40100/tcp as one member per port, so
+// a range and the single ports it spans are the same.
+func portsNotIn(a, b []string) []string {
+	covered := make(map[portKey]bool)
+	for _, p := range b {
+		for _, k := range expandPort(p) {
+			covered[k] = true
+		}
+	}
+
+	var out []string
+	for _, p := range a {
+		if slices.ContainsFunc(expandPort(p), func(k portKey) bool { return !covered[k] }) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// portKey is one port of one protocol. Ports that do not parse are kept
+// as written in raw.
+type portKey struct {
+	proto string
+	port  int
+	raw   string
+}
+
+// returns every port of "22/tcp" or "40000:40100/udp". The protocol
+// defaults to tcp, as in the firewall backends.
+func expandPort(p string) []portKey {
+	port, proto, _ := strings.Cut(strings.TrimSpace(p), "/")
+	proto = strings.ToLower(proto)
+	if proto == "" {
+		proto = "tcp"
+	}
+
+	lo, hi, isRange := strings.Cut(port, ":")
+	if !isRange {
+		hi = lo
+	}
+	from, err1 := strconv.Atoi(lo)
+	to, err2 := strconv.Atoi(hi)
+	if err1 != nil || err2 != nil || from < 0 || to > 65535 || from > to {
+		return []portKey{{proto: proto, raw: port}}
+	}
+
+	out := make([]portKey, 0, to-from+1)
+	for n := from; n <= to; n++ {
+		out = append(out, portKey{proto: proto, port: n})
+	}
+	return out
+}
+
+// saves the report for LastDrift.
+func (eng *Engine) storeDrift(report DriftReport) {
+	eng.driftMu.Lock()
+	eng.lastDrift = report
+	eng.driftMu.Unlock()
+}
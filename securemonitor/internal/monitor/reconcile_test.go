@@ -0,0 +1,45 @@
+package monitor
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestPortsNotIn(t *testing.T) {
+	// ipset save lists a stored range one port at a time.
+	listed := func(from, to int) []string {
+		var out []string
+		for n := from; n <= to; n++ {
+			out = append(out, fmt.Sprintf("%d/tcp", n))
+		}
+		return out
+	}
+
+	tests := []struct {
+		name string
+		a, b []string
+		want []string
+		same bool
+	}{
+		{"same ports", []string{"22/tcp", "21/tcp"}, []string{"21/tcp", "22/tcp"}, nil, true},
+		{"default protocol", []string{"22"}, []string{"22/tcp"}, nil, true},
+		{"other protocol", []string{"53/udp"}, []string{"53/tcp"}, []string{"53/udp"}, false},
+		{"range listed per port", []string{"21/tcp", "40000:40100/tcp"}, append([]string{"21/tcp"}, listed(40000, 40100)...), nil, true},
+		{"range partly listed", []string{"40000:40100/tcp"}, listed(40000, 40050), []string{"40000:40100/tcp"}, false},
+		{"single port inside a range", []string{"40010/tcp"}, []string{"40000:40100/tcp"}, nil, false},
+		{"unparsable port", []string{"ftp/tcp"}, []string{"ftp/tcp"}, nil, true},
+		{"host-wide and scoped", nil, []string{"22/tcp"}, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := portsNotIn(tt.a, tt.b); !slices.Equal(got, tt.want) {
+				t.Errorf("portsNotIn = %v, want %v", got, tt.want)
+			}
+			if got := samePorts(tt.a, tt.b); got != tt.same {
+				t.Errorf("samePorts = %v, want %v", got, tt.same)
+			}
+		})
+	}
+}
@@ -3,10 +3,10 @@ package monitor
 import (
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"securemonitor/internal/config"
-	"securemonitor/internal/firewall"
 	"securemonitor/internal/storage"
 )
 
@@ -15,9 +15,36 @@ type ServiceStrategy interface {
 	// Name returns the identifier of the service (ssh, ftp, apache, ...).
 	Name() string
 
+	// Ports returns the ports the service listens on ("22/tcp"), used to
+	// scope blocks when block_scope is "service".
+	Ports(cfg config.Config) []string
+
 	// handles the events detected in the current scan cycle.
 	// events: ip -> count of new events in this cycle.
-	ProcessEvents(events map[string]int, cfg config.Config, now time.Time, whitelist map[string]struct{})
+	ProcessEvents(events map[string]int, cfg config.Config, now time.Time, whitelist Whitelist)
+}
+
+// TotalsReporter is implemented by strategies that accumulate events per
+// IP across scans.
+type TotalsReporter interface {
+	// Total returns the events accumulated for the IP so far.
+	Total(ip string) int
+}
+
+// returns the accumulated events per service for the IP (services with
+// none are left out).
+func (eng *Engine) FailureTotals(ip string) map[string]int {
+	out := make(map[string]int)
+	for _, s := range eng.strategies() {
+		r, ok := s.(TotalsReporter)
+		if !ok {
+			continue
+		}
+		if n := r.Total(ip); n > 0 {
+			out[s.Name()] = n
+		}
+	}
+	return out
 }
 
 //  builds a log prefix like [SSH], [FTP], [APACHE] from a service name.
@@ -31,38 +58,53 @@ type loginServiceConfig struct {
 	defaultThresh int                         // fallback if no cfg values
 	getSpecific   func(cfg config.Config) int // cfg.SSHMaxFailures / cfg.FTPMaxFailures
 	incCounter    func(n int)                 // IncSSHBy / IncFTPBy
+	defaultPorts  []string                    // used when service_ports has no entry
+	extraPorts    func(cfg config.Config) []string
 }
 
 type LoginServiceStrategy struct {
+	eng    *Engine
 	cfg    loginServiceConfig
+	mu     sync.Mutex
 	totals map[string]int // accumulated failures per IP across scans
 }
 
-// builds a strategy for SSH failed-logins.
-func NewSSHStrategy() *LoginServiceStrategy {
+// builds a strategy for SSH failed-logins enforced by eng.
+func NewSSHStrategy(eng *Engine) *LoginServiceStrategy {
 	return &LoginServiceStrategy{
+		eng: eng,
 		cfg: loginServiceConfig{
 			name:          "ssh",
 			defaultThresh: 3,
 			getSpecific: func(cfg config.Config) int {
 				return cfg.SSHMaxFailures
 			},
-			incCounter: IncSSHBy,
+			incCounter:   IncSSHBy,
+			defaultPorts: []string{"22/tcp"},
 		},
 		totals: make(map[string]int),
 	}
 }
 
-//  builds a strategy for FTP failed-logins.
-func NewFTPStrategy() *LoginServiceStrategy {
+//  builds a strategy for FTP failed-logins enforced by eng.
+func NewFTPStrategy(eng *Engine) *LoginServiceStrategy {
 	return &LoginServiceStrategy{
+		eng: eng,
 		cfg: loginServiceConfig{
 			name:          "ftp",
 			defaultThresh: 3,
 			getSpecific: func(cfg config.Config) int {
 				return cfg.FTPMaxFailures
 			},
-			incCounter: IncFTPBy,
+			incCounter:   IncFTPBy,
+			defaultPorts: []string{"21/tcp"},
+			// passive data connections must be covered too.
+			extraPorts: func(cfg config.Config) []string {
+				if cfg.FTPPassivePorts == "" {
+					return nil
+				}
+				return []string{cfg.FTPPassivePorts + "/tcp"}
+			},
 		},
 		totals: make(map[string]int),
 	}
@@ -72,9 +114,32 @@ func (s *LoginServiceStrategy) Name() string {
 	return s.cfg.name
 }
 
+func (s *LoginServiceStrategy) Total(ip string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.totals[ip]
+}
+
+func (s *LoginServiceStrategy) Ports(cfg config.Config) []string {
+	ports := servicePorts(cfg, s.cfg.name, s.cfg.defaultPorts)
+	if s.cfg.extraPorts != nil {
+		ports = append(ports, s.cfg.extraPorts(cfg)...)
+	}
+	return ports
+}
+
+// returns the ports configured for the service in service_ports,
+// or the strategy defaults.
+func servicePorts(cfg config.Config, service string, defaults []string) []string {
+	if ports, ok := cfg.ServicePorts[service]; ok && len(ports) > 0 {
+		return append([]string(nil), ports...)
+	}
+	return append([]string(nil), defaults...)
+}
+
 //  processes SSH/FTP login failures per cycle and enforces
 // stats, alerts and firewall blocks.
-func (s *LoginServiceStrategy) ProcessEvents(events map[string]int, cfg config.Config, now time.Time, whitelist map[string]struct{}) {
+func (s *LoginServiceStrategy) ProcessEvents(events map[string]int, cfg config.Config, now time.Time, whitelist Whitelist) {
 	if len(events) == 0 {
 		return
 	}
@@ -97,23 +162,33 @@ func (s *LoginServiceStrategy) ProcessEvents(events map[string]int, cfg config.C
 		s.cfg.incCounter(newFails)
 
 		// 2) Update accumulated total per IP.
+		s.mu.Lock()
 		oldTotal := s.totals[ip]
 		total := oldTotal + newFails
 		s.totals[ip] = total
+		s.mu.Unlock()
 
 		// 3) Log and alert.
-		storage.AddLog(fmt.Sprintf(
-			"%s %d new failed logins from %s (total=%d)",
-			prefix, newFails, ip, total,
-		))
+		s.eng.store.Log(storage.LogEntry{
+			Component: service,
+			Event:     "failed_logins",
+			IP:        ip,
+			Host:      s.eng.eventHost(service, ip),
+			Fields:    storage.Fields{"new": newFails, "total": total},
+			Message: fmt.Sprintf(
+				"%s %d new failed logins from %s (total=%d)",
+				prefix, newFails, ip, total,
+			),
+		})
 
 		severity := classifySeverity(service, newFails, total, threshold)
 		country := lookupCountry(ip)
 
-		storage.AddAlert(storage.Alert{
+		s.eng.store.AddAlert(storage.Alert{
 			Timestamp: now.Format(time.RFC3339),
 			Service:   service,
 			IP:        ip,
+			Host:      s.eng.eventHost(service, ip),
 			Country:   country,
 			Severity:  severity,
 			Message: fmt.Sprintf(
@@ -128,12 +203,8 @@ func (s *LoginServiceStrategy) ProcessEvents(events map[string]int, cfg config.C
 		}
 
 		if total >= threshold {
-			storage.AddLog(fmt.Sprintf(
-				"%s Blocking %s (total fails=%d, threshold=%d)",
-				prefix, ip, total, threshold,
-			))
-			firewall.BlockIP(ip)
-			storage.AddBlocked(ip)
+			action := serviceAction(cfg, service, ActionBlock)
+			s.eng.enforce(cfg, service, action, s.Ports(cfg), ip, fmt.Sprintf("total fails=%d, threshold=%d", total, threshold), now)
 			// Optionally: s.totals[ip] = 0
 		}
 	}
@@ -141,20 +212,34 @@ func (s *LoginServiceStrategy) ProcessEvents(events map[string]int, cfg config.C
 
 // ----------------APACHE---------------
 
-type ApacheStrategy struct{}
+type ApacheStrategy struct {
+	eng    *Engine
+	mu     sync.Mutex
+	totals map[string]int // accumulated errors per IP across scans
+}
 
-// builds a strategy for Apache error monitoring.
-func NewApacheStrategy() *ApacheStrategy {
-	return &ApacheStrategy{}
+// builds a strategy for Apache error monitoring enforced by eng.
+func NewApacheStrategy(eng *Engine) *ApacheStrategy {
+	return &ApacheStrategy{eng: eng, totals: make(map[string]int)}
+}
+
+func (s *ApacheStrategy) Total(ip string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.totals[ip]
 }
 
 func (s *ApacheStrategy) Name() string {
 	return "apache"
 }
 
+func (s *ApacheStrategy) Ports(cfg config.Config) []string {
+	return servicePorts(cfg, "apache", []string{"80/tcp", "443/tcp"})
+}
+
 // processes Apache 4xx/5xx errors for this cycle, updates stats,
 // generates alerts and may block IPs according to config.
-func (s *ApacheStrategy) ProcessEvents(apacheErrors map[string]int, cfg config.Config, now time.Time, whitelist map[string]struct{}) {
+func (s *ApacheStrategy) ProcessEvents(apacheErrors map[string]int, cfg config.Config, now time.Time, whitelist Whitelist) {
 	if len(apacheErrors) == 0 {
 		return
 	}
@@ -174,23 +259,41 @@ func (s *ApacheStrategy) ProcessEvents(apacheErrors map[string]int, cfg config.C
 		return
 	}
 
+	// apache_block_on_threshold picks the default response; service_actions
+	// overrides it.
+	fallback := ActionAlert
+	if cfg.ApacheBlockOnThreshold {
+		fallback = ActionBlock
+	}
+	action := serviceAction(cfg, "apache", fallback)
+
 	// Update global Apache counter.
 	IncApacheBy(totalApacheErrors)
 
-	storage.AddLog(fmt.Sprintf(
-		"[APACHE] Errors detected this cycle: %d (ips=%d)",
-		totalApacheErrors, len(apacheErrors),
-	))
+	s.eng.store.Log(storage.LogEntry{
+		Component: "apache",
+		Event:     "http_errors",
+		Fields:    storage.Fields{"errors": totalApacheErrors, "ips": len(apacheErrors)},
+		Message: fmt.Sprintf(
+			"[APACHE] Errors detected this cycle: %d (ips=%d)",
+			totalApacheErrors, len(apacheErrors),
+		),
+	})
 
 	// One alert per IP.
 	for ip, count := range apacheErrors {
+		s.mu.Lock()
+		s.totals[ip] += count
+		s.mu.Unlock()
+
 		severity := classifySeverity("apache", count, count, threshold)
 		country := lookupCountry(ip)
 
-		storage.AddAlert(storage.Alert{
+		s.eng.store.AddAlert(storage.Alert{
 			Timestamp: now.Format(time.RFC3339),
 			Service:   "apache",
 			IP:        ip,
+			Host:      s.eng.eventHost("apache", ip),
 			Country:   country,
 			Severity:  severity,
 			Message: fmt.Sprintf(
@@ -199,18 +302,13 @@ func (s *ApacheStrategy) ProcessEvents(apacheErrors map[string]int, cfg config.C
 			),
 		})
 
-		// Optional blocking policy for Apache.
-		if cfg.ApacheBlockOnThreshold &&
+		// Optional blocking or rate-limiting policy for Apache.
+		if action != ActionAlert &&
 			!isLoopback(ip) &&
 			!isWhitelisted(ip, whitelist) &&
 			count >= threshold {
 
-			storage.AddLog(fmt.Sprintf(
-				"[APACHE] Blocking %s (errors this cycle=%d, threshold=%d)",
-				ip, count, threshold,
-			))
-			firewall.BlockIP(ip)
-			storage.AddBlocked(ip)
+			s.eng.enforce(cfg, "apache", action, s.Ports(cfg), ip, fmt.Sprintf("errors this cycle=%d, threshold=%d", count, threshold), now)
 		}
 	}
 }
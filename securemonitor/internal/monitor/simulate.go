@@ -1,6 +1,10 @@
 package monitor
 
-import "sync"
+import (
+	"sync"
+
+	"securemonitor/internal/ipaddr"
+)
 
 var (
 	simMu        sync.Mutex
@@ -11,6 +15,7 @@ var (
 
 //  schedules n simulated SSH failures for a given IP.
 func AddSimulatedSSH(ip string, n int) {
+	ip = ipaddr.Canonical(ip)
 	if n <= 0 || ip == "" {
 		return
 	}
@@ -21,6 +26,7 @@ func AddSimulatedSSH(ip string, n int) {
 
 //  schedules n simulated FTP failures for a given IP.
 func AddSimulatedFTP(ip string, n int) {
+	ip = ipaddr.Canonical(ip)
 	if n <= 0 || ip == "" {
 		return
 	}
@@ -31,6 +37,7 @@ func AddSimulatedFTP(ip string, n int) {
 
 //  schedules n simulated Apache errors for a given IP.
 func AddSimulatedApache(ip string, n int) {
+	ip = ipaddr.Canonical(ip)
 	if n <= 0 || ip == "" {
 		return
 	}
@@ -1,20 +1,24 @@
 package monitor
 
-import "strings"
+import (
+	"strings"
+
+	"securemonitor/internal/storage"
+)
 
 //  aggregates failed SSH login attempts
 // for each IP from raw log lines.
-func parseSSHFailuresFromLines(lines []string) map[string]int {
+func (eng *Engine) parseSSHFailuresFromLines(lines []logLine) map[string]int {
 	failures := make(map[string]int)
 
 	for _, raw := range lines {
-		line := strings.TrimSpace(raw)
+		line := strings.TrimSpace(raw.Text)
 		if line == "" {
 			continue
 		}
 
 		// Only consider entries from sshd.
-		if !strings.Contains(line, "sshd") {
+		if !raw.from("sshd") {
 			continue
 		}
 
@@ -23,18 +27,11 @@ func parseSSHFailuresFromLines(lines []string) map[string]int {
 			ip := extractIP(line)
 			if ip != "" {
 				failures[ip]++
+				eng.store.AddMatchedLine(ip, storage.MatchedLine{Time: raw.Time, Service: "ssh", Host: raw.Host, Line: line})
+				eng.noteEventHost("ssh", ip, raw.Host)
 			}
 		}
 	}
 
 	return failures
 }
-
-//  reads new SSH log lines from disk and returns per-IP failure counts.
-func parseSSHFailures(path string) map[string]int {
-	lines, err := ReadNewLines(path)
-	if err != nil || len(lines) == 0 {
-		return map[string]int{}
-	}
-	return parseSSHFailuresFromLines(lines)
-}
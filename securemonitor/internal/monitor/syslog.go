@@ -0,0 +1,306 @@
+package monitor
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"securemonitor/internal/config"
+	"securemonitor/internal/ipaddr"
+)
+
+const (
+	maxSyslogMessage = 64 << 10 // longer messages are dropped
+	maxSyslogConns   = 256      // TCP senders served at once
+	maxSyslogQueue   = 100000   // messages kept between two scans
+	maxSyslogHost    = 255
+	syslogIdle       = 10 * time.Minute // a quiet TCP sender is hung up on
+)
+
+// syslogReceiver accepts messages forwarded by other hosts.
+type syslogReceiver struct {
+	allowed Whitelist
+	conns   chan struct{} // one slot per open TCP connection
+	push    func(service string, line logLine)
+
+	rejectedMu sync.Mutex
+	rejected   map[string]bool // senders refused, logged once
+}
+
+// starts the syslog listeners configured in cfg, if any.
+func (eng *Engine) startSyslog(cfg config.Config) {
+	if cfg.SyslogUDP == "" && cfg.SyslogTCP == "" {
+		return
+	}
+
+	if len(cfg.SyslogAllowed) == 0 {
+		log.Printf("syslog: syslog_allowed is empty, accepting loopback senders only")
+	}
+	r := newSyslogReceiver(cfg, eng.pushSyslog)
+
+	if cfg.SyslogUDP != "" {
+		conn, err := net.ListenPacket("udp", cfg.SyslogUDP)
+		if err != nil {
+			log.Printf("syslog: cannot listen on udp %s: %v", cfg.SyslogUDP, err)
+		} else {
+			log.Printf("syslog: listening on udp %s", conn.LocalAddr())
+			go r.serveUDP(conn)
+		}
+	}
+	if cfg.SyslogTCP != "" {
+		ln, err := net.Listen("tcp", cfg.SyslogTCP)
+		if err != nil {
+			log.Printf("syslog: cannot listen on tcp %s: %v", cfg.SyslogTCP, err)
+		} else {
+			log.Printf("syslog: listening on tcp %s", ln.Addr())
+			go r.serveTCP(ln)
+		}
+	}
+}
+
+// builds the receiver for cfg, handing the messages to push. Forwarded
+// lines can get any address blocked, so only the senders in
+// syslog_allowed are heard, or loopback ones when it is empty.
+func newSyslogReceiver(cfg config.Config, push func(service string, line logLine)) *syslogReceiver {
+	allowed := cfg.SyslogAllowed
+	if len(allowed) == 0 {
+		allowed = []string{"127.0.0.0/8", "::1"}
+	}
+	wl := parseWhitelist(allowed)
+	for _, s := range wl.invalid {
+		log.Printf("syslog: ignoring syslog_allowed entry %q, not an address or CIDR range", s)
+	}
+	return &syslogReceiver{
+		allowed:  wl,
+		conns:    make(chan struct{}, maxSyslogConns),
+		push:     push,
+		rejected: make(map[string]bool),
+	}
+}
+
+// returns the messages received for service since the last call, oldest
+// first.
+func (eng *Engine) drainSyslog(service string) []logLine {
+	eng.syslogMu.Lock()
+	defer eng.syslogMu.Unlock()
+
+	lines := eng.syslogQueue[service]
+	delete(eng.syslogQueue, service)
+	eng.syslogQueued -= len(lines)
+	if eng.syslogDropped > 0 {
+		log.Printf("syslog: dropped %d messages, the scan loop fell behind", eng.syslogDropped)
+		eng.syslogDropped = 0
+	}
+	return lines
+}
+
+// queues a message for the parser of service and wakes the loop. Past
+// the queue bound new messages are dropped: the loop is not keeping up.
+func (eng *Engine) pushSyslog(service string, line logLine) {
+	eng.syslogMu.Lock()
+	if eng.syslogQueued >= maxSyslogQueue {
+		eng.syslogDropped++
+		eng.syslogMu.Unlock()
+		return
+	}
+	eng.syslogQueue[service] = append(eng.syslogQueue[service], line)
+	eng.syslogQueued++
+	eng.syslogMu.Unlock()
+
+	eng.wakeLoop()
+}
+
+// returns the service whose parser reads the messages of program, or ""
+// for programs nobody watches.
+func syslogService(program string) string {
+	switch program {
+	case "sshd":
+		return "ssh"
+	case "vsftpd":
+		return "ftp"
+	case "apache", "apache2", "httpd":
+		return "apache"
+	}
+	if strings.HasPrefix(program, "sshd-") {
+		return "ssh"
+	}
+	return ""
+}
+
+// returns the address of the sender, and whether it may send messages.
+func (r *syslogReceiver) sender(addr net.Addr) (string, bool) {
+	ip := ipaddr.Canonical(addr.String())
+	if ip == "" {
+		return "", false
+	}
+	if isWhitelisted(ip, r.allowed) {
+		return ip, true
+	}
+
+	r.rejectedMu.Lock()
+	defer r.rejectedMu.Unlock()
+	if !r.rejected[ip] && len(r.rejected) < maxSyslogConns {
+		r.rejected[ip] = true
+		log.Printf("syslog: ignoring %s, not in syslog_allowed", ip)
+	}
+	return "", false
+}
+
+// hands one message to the parser of its program. The host it names is
+// kept, or the sender address when it names none.
+func (r *syslogReceiver) handle(msg []byte, sender string) {
+	m, ok := parseSyslog(msg, time.Now())
+	if !ok {
+		return
+	}
+	service := syslogService(m.Program)
+	if service == "" {
+		return
+	}
+
+	host := m.Host
+	if !validSyslogHost(host) {
+		host = sender
+	}
+	r.push(service, logLine{Time: m.Time, Program: m.Program, Host: host, Text: m.Text})
+}
+
+// reports whether h can stand for a host in logs, alerts and the
+// dashboard: a hostname or address, nothing else.
+func validSyslogHost(h string) bool {
+	if h == "" || len(h) > maxSyslogHost {
+		return false
+	}
+	for _, c := range h {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.', c == '-', c == '_', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// reads one message per datagram.
+func (r *syslogReceiver) serveUDP(conn net.PacketConn) {
+	buf := make([]byte, maxSyslogMessage)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("syslog: udp: %v", err)
+			continue
+		}
+		if sender, ok := r.sender(addr); ok {
+			r.handle(buf[:n], sender)
+		}
+	}
+}
+
+// accepts TCP senders, up to maxSyslogConns at once.
+func (r *syslogReceiver) serveTCP(ln net.Listener) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("syslog: tcp: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		sender, ok := r.sender(c.RemoteAddr())
+		if !ok {
+			c.Close()
+			continue
+		}
+		select {
+		case r.conns <- struct{}{}:
+		default:
+			log.Printf("syslog: too many tcp senders, refusing %s", sender)
+			c.Close()
+			continue
+		}
+
+		go func() {
+			defer func() { <-r.conns }()
+			r.serveConn(c, sender)
+		}()
+	}
+}
+
+// reads the messages of one TCP sender until it hangs up.
+func (r *syslogReceiver) serveConn(c net.Conn, sender string) {
+	defer c.Close()
+
+	br := bufio.NewReaderSize(c, lineBufferSize)
+	for {
+		c.SetReadDeadline(time.Now().Add(syslogIdle))
+		msg, err := readSyslogFrame(br)
+		if len(msg) > 0 {
+			r.handle(msg, sender)
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("syslog: tcp %s: %v", sender, err)
+			}
+			return
+		}
+	}
+}
+
+// reads one message from a TCP stream (RFC 6587): octet-counted, as
+// "LEN SP MSG", when it starts with a digit, else up to the next LF.
+func readSyslogFrame(br *bufio.Reader) ([]byte, error) {
+	first, err := br.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if first[0] >= '1' && first[0] <= '9' {
+		n := 0
+		for i := 0; ; i++ {
+			c, err := br.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if c == ' ' {
+				break
+			}
+			if c < '0' || c > '9' || i >= 6 {
+				return nil, errors.New("invalid octet count")
+			}
+			n = n*10 + int(c-'0')
+		}
+		if n > maxSyslogMessage {
+			return nil, fmt.Errorf("message of %d bytes is too long", n)
+		}
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(br, msg); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	}
+
+	var msg []byte
+	for {
+		chunk, err := br.ReadSlice('\n')
+		msg = append(msg, chunk...)
+		if len(msg) > maxSyslogMessage {
+			return nil, errors.New("message too long")
+		}
+		if err != bufio.ErrBufferFull {
+			return msg, err
+		}
+	}
+}
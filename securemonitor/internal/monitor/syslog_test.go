@@ -0,0 +1,214 @@
+package monitor
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"securemonitor/internal/config"
+)
+
+func TestReadSyslogFrame(t *testing.T) {
+	counted := func(msgs ...string) string {
+		var b strings.Builder
+		for _, m := range msgs {
+			fmt.Fprintf(&b, "%d %s", len(m), m)
+		}
+		return b.String()
+	}
+
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr bool // ends with an error other than io.EOF
+	}{
+		{"lf framed", "<34>one\n<34>two\n", []string{"<34>one\n", "<34>two\n"}, false},
+		{"last line without lf", "<34>one\n<34>two", []string{"<34>one\n", "<34>two"}, false},
+		{"octet counted", counted("<34>one", "<34>two\nwith lf"), []string{"<34>one", "<34>two\nwith lf"}, false},
+		{"mixed framing", counted("<34>one") + "<34>two\n", []string{"<34>one", "<34>two\n"}, false},
+		{"truncated count", "20 <34>short", nil, true},
+		{"count not a number", "12a <34>x", nil, true},
+		{"count too long", "1234567 x", nil, true},
+		{"count over the limit", "999999 x", nil, true},
+		{"lf message over the limit", "<34>" + strings.Repeat("a", maxSyslogMessage) + "\n", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			br := bufio.NewReader(strings.NewReader(tt.input))
+
+			var got []string
+			var err error
+			for err == nil {
+				var msg []byte
+				msg, err = readSyslogFrame(br)
+				if len(msg) > 0 {
+					got = append(got, string(msg))
+				}
+			}
+
+			if tt.wantErr == errors.Is(err, io.EOF) {
+				t.Errorf("final error = %v, want error other than EOF: %v", err, tt.wantErr)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("frames = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSyslog(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		msg  string
+		now  time.Time // zero = now
+		want syslogMessage
+		ok   bool
+	}{
+		{
+			name: "rfc 3164",
+			msg:  "<38>Mar 10 11:59:01 web1 sshd[123]: Failed password for root from 203.0.113.5 port 22 ssh2\n",
+			want: syslogMessage{
+				Time:    time.Date(2024, 3, 10, 11, 59, 1, 0, time.UTC),
+				Host:    "web1",
+				Program: "sshd",
+				Text:    "Failed password for root from 203.0.113.5 port 22 ssh2",
+			},
+			ok: true,
+		},
+		{
+			name: "rfc 3164 without host",
+			msg:  "<38>Mar  9 08:00:00 vsftpd[77]: FAIL LOGIN: Client \"203.0.113.5\"",
+			want: syslogMessage{
+				Time:    time.Date(2024, 3, 9, 8, 0, 0, 0, time.UTC),
+				Program: "vsftpd",
+				Text:    "FAIL LOGIN: Client \"203.0.113.5\"",
+			},
+			ok: true,
+		},
+		{
+			name: "rfc 3164 from last year",
+			msg:  "<38>Dec 31 23:59:00 web1 sshd: bye",
+			now:  time.Date(2024, 1, 1, 0, 10, 0, 0, time.UTC),
+			want: syslogMessage{
+				Time:    time.Date(2023, 12, 31, 23, 59, 0, 0, time.UTC),
+				Host:    "web1",
+				Program: "sshd",
+				Text:    "bye",
+			},
+			ok: true,
+		},
+		{
+			name: "rfc 3164 with rfc 3339 time",
+			msg:  "<38>2024-03-10T11:59:01Z web1 sshd[1]: hello",
+			want: syslogMessage{
+				Time:    time.Date(2024, 3, 10, 11, 59, 1, 0, time.UTC),
+				Host:    "web1",
+				Program: "sshd",
+				Text:    "hello",
+			},
+			ok: true,
+		},
+		{
+			name: "rfc 3164 tag only",
+			msg:  "<38>sshd[1]: hello",
+			want: syslogMessage{Program: "sshd", Text: "hello"},
+			ok:   true,
+		},
+		{
+			name: "rfc 5424",
+			msg:  "<165>1 2024-03-10T11:59:01.003Z host1 sshd 1234 ID47 - Failed password for admin from 198.51.100.2 port 4022 ssh2",
+			want: syslogMessage{
+				Time:    time.Date(2024, 3, 10, 11, 59, 1, 3000000, time.UTC),
+				Host:    "host1",
+				Program: "sshd",
+				Text:    "Failed password for admin from 198.51.100.2 port 4022 ssh2",
+			},
+			ok: true,
+		},
+		{
+			name: "rfc 5424 with structured data and bom",
+			msg:  "<165>1 2024-03-10T11:59:01Z host1 sshd - - [ex@1 a=\"x\\\"]\"][meta b=\"c\"] \ufeffAccepted",
+			want: syslogMessage{
+				Time:    time.Date(2024, 3, 10, 11, 59, 1, 0, time.UTC),
+				Host:    "host1",
+				Program: "sshd",
+				Text:    "Accepted",
+			},
+			ok: true,
+		},
+		{
+			name: "rfc 5424 with nil values",
+			msg:  "<165>1 - - sshd - - - hello",
+			want: syslogMessage{Program: "sshd", Text: "hello"},
+			ok:   true,
+		},
+		{name: "no priority", msg: "Mar 10 11:59:01 web1 sshd: hi"},
+		{name: "priority not a number", msg: "<ab>hi"},
+		{name: "priority too long", msg: "<1234>hi"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at := tt.now
+			if at.IsZero() {
+				at = now
+			}
+
+			got, ok := parseSyslog([]byte(tt.msg), at)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if !got.Time.Equal(tt.want.Time) || got.Host != tt.want.Host || got.Program != tt.want.Program || got.Text != tt.want.Text {
+				t.Errorf("parseSyslog = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyslogSender(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		sender  string
+		ok      bool
+	}{
+		{"default loopback v4", nil, "127.0.0.1", true},
+		{"default loopback v6", nil, "::1", true},
+		{"default remote", nil, "203.0.113.5", false},
+		{"listed range", []string{"198.51.100.0/24"}, "198.51.100.7", true},
+		{"listed address", []string{"198.51.100.7"}, "198.51.100.7", true},
+		{"listed host range", []string{"10.0.0.5/32"}, "10.0.0.5", true},
+		{"listed v6 host range", []string{"2001:db8::5/128"}, "2001:db8::5", true},
+		{"next to a host range", []string{"10.0.0.5/32"}, "10.0.0.6", false},
+		{"invalid entry", []string{"10.0.0.5/40"}, "10.0.0.5", false},
+		{"not listed", []string{"198.51.100.0/24"}, "203.0.113.5", false},
+		{"loopback when a list is set", []string{"198.51.100.0/24"}, "127.0.0.1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newSyslogReceiver(config.Config{SyslogAllowed: tt.allowed}, nil)
+			addr := &net.UDPAddr{IP: net.ParseIP(tt.sender), Port: 514}
+
+			got, ok := r.sender(addr)
+			if ok != tt.ok {
+				t.Fatalf("sender(%s) ok = %v, want %v", tt.sender, ok, tt.ok)
+			}
+			if ok && got != tt.sender {
+				t.Errorf("sender(%s) = %q", tt.sender, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package monitor
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// syslogMessage is a received syslog message, split into the parts the
+// parsers need.
+type syslogMessage struct {
+	Time    time.Time // zero when the sender left it out
+	Host    string    // "" when the sender left it out
+	Program string    // APP-NAME (RFC 5424) or TAG (RFC 3164)
+	Text    string
+}
+
+// parses a syslog message in RFC 5424 or RFC 3164 (BSD) format. now
+// supplies the year RFC 3164 timestamps lack. Reports false when the
+// message does not start with a priority.
+func parseSyslog(msg []byte, now time.Time) (syslogMessage, bool) {
+	s := strings.TrimRight(string(msg), "\r\n\x00")
+
+	// <PRI>, 1 to 3 digits.
+	end := strings.IndexByte(s, '>')
+	if !strings.HasPrefix(s, "<") || end < 2 || end > 4 {
+		return syslogMessage{}, false
+	}
+	if _, err := strconv.Atoi(s[1:end]); err != nil {
+		return syslogMessage{}, false
+	}
+	s = s[end+1:]
+
+	if rest, ok := strings.CutPrefix(s, "1 "); ok {
+		return parseSyslog5424(rest), true
+	}
+	return parseSyslog3164(s, now), true
+}
+
+// parses what follows "<PRI>1 ":
+//
+//	TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]
+//
+// with "-" for the values left out.
+func parseSyslog5424(s string) syslogMessage {
+	var m syslogMessage
+	var header [5]string
+	for i := range header {
+		header[i], s, _ = strings.Cut(s, " ")
+		if header[i] == "-" {
+			header[i] = ""
+		}
+	}
+	if t, err := time.Parse(time.RFC3339Nano, header[0]); err == nil {
+		m.Time = t
+	}
+	m.Host = header[1]
+	m.Program = header[2]
+
+	s = skipStructuredData(s)
+	m.Text = strings.TrimPrefix(strings.TrimPrefix(s, " "), "\ufeff") // BOM
+	return m
+}
+
+// returns s after its STRUCTURED-DATA: "-" or a run of [ID name="value"]
+// elements.
+func skipStructuredData(s string) string {
+	if rest, ok := strings.CutPrefix(s, "-"); ok {
+		return rest
+	}
+	for strings.HasPrefix(s, "[") {
+		end := sdElementEnd(s)
+		if end < 0 {
+			return "" // unterminated element
+		}
+		s = s[end+1:]
+	}
+	return s
+}
+
+// returns the index of the ']' closing the element at the start of s, or
+// -1. Values are quoted and may escape '"', '\' and ']'.
+func sdElementEnd(s string) int {
+	quoted := false
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case ']':
+			if !quoted {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// parses what follows "<PRI>" in the BSD format:
+//
+//	Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG
+//
+// Senders differ: the timestamp may be RFC 3339 (rsyslog forwarding) and
+// the timestamp or hostname may be missing.
+func parseSyslog3164(s string, now time.Time) syslogMessage {
+	var m syslogMessage
+
+	if len(s) >= len(time.Stamp) {
+		if t, err := time.ParseInLocation(time.Stamp, s[:len(time.Stamp)], now.Location()); err == nil {
+			// no year: the latest one that is not in the future.
+			m.Time = time.Date(now.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, now.Location())
+			if m.Time.After(now.Add(24 * time.Hour)) {
+				m.Time = m.Time.AddDate(-1, 0, 0)
+			}
+			s = strings.TrimPrefix(s[len(time.Stamp):], " ")
+		}
+	}
+	if m.Time.IsZero() {
+		token, rest, _ := strings.Cut(s, " ")
+		if t, err := time.Parse(time.RFC3339Nano, token); err == nil {
+			m.Time = t
+			s = rest
+		}
+	}
+
+	// a hostname is a bare word; the tag ends with ':' or "[pid]:".
+	if token, rest, ok := strings.Cut(s, " "); ok && !isSyslogTag(token) {
+		m.Host = token
+		s = rest
+	}
+
+	tagEnd := strings.IndexAny(s, "[: ")
+	if tagEnd < 0 {
+		m.Text = s
+		return m
+	}
+	m.Program = s[:tagEnd]
+	s = s[tagEnd:]
+	if strings.HasPrefix(s, "[") {
+		if i := strings.IndexByte(s, ']'); i >= 0 {
+			s = s[i+1:]
+		}
+	}
+	s = strings.TrimPrefix(s, ":")
+	m.Text = strings.TrimPrefix(s, " ")
+	return m
+}
+
+// reports whether the token is a TAG rather than a hostname.
+func isSyslogTag(token string) bool {
+	return strings.HasSuffix(token, ":") || strings.Contains(token, "[")
+}
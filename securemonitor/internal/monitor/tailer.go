@@ -1,61 +1,292 @@
 package monitor
 
 import (
+	"io"
+	"log"
 	"os"
+	"sort"
 	"strings"
+	"time"
+
+	"securemonitor/internal/config"
 )
 
-//  keeps track of the last read byte offset per log file.
-var lastOffsets = make(map[string]int64)
+// follower reads a log file as it grows. It keeps the file open and
+// remembers its device and inode, so when logrotate renames the file
+// away and creates a new one, the lines written to the old file since
+// the last read are drained before switching to the new one.
+type follower struct {
+	path    string
+	started bool // false until the first read, which skips history
 
-//  returns only the new lines appended to the file since the last call.
-func ReadNewLines(path string) ([]string, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
+	// where the previous run stopped, if it followed the path.
+	saved *tailState
+	// how far back rotated siblings are read; 0 disables the catch-up.
+	lookBack time.Duration
+
+	file   *os.File
+	info   os.FileInfo // of file, to recognise it after a rename
+	id     fileKey
+	offset int64 // end of the last complete line read
+	lines  *lineReader
+
+	// rotated files to finish before the one at the path, oldest first.
+	pending []*pendingFile
+
+	lastRead time.Time
+}
+
+// pendingFile is a rotated log still being read.
+type pendingFile struct {
+	rc    io.ReadCloser
+	lines *lineReader
+}
+
+// fileKey identifies a file on disk independently of its name.
+type fileKey struct {
+	Dev uint64
+	Ino uint64
+}
+
+// default cap on what one follower reads per cycle.
+const defaultReadLimit = 16 << 20
+
+// applies the reading settings of cfg to every follower.
+func (eng *Engine) configureFollowers(cfg config.Config) {
+	eng.followersMu.Lock()
+	defer eng.followersMu.Unlock()
+
+	eng.catchUpLookBack = time.Duration(cfg.CatchUpHours) * time.Hour
+	eng.readLimit = int64(cfg.MaxReadMB) << 20
+	if eng.readLimit <= 0 {
+		eng.readLimit = defaultReadLimit
 	}
-	defer f.Close()
+}
+
+// returns only the complete lines appended to the file since the last
+// call, at most the configured read limit; the rest is returned by the
+// next calls. The first call resumes at the offset saved by the previous
+// run, or skips what is already in the file when there is none.
+func (eng *Engine) ReadNewLines(path string) ([]string, error) {
+	eng.followersMu.Lock()
+	defer eng.followersMu.Unlock()
 
-	info, err := f.Stat()
+	f, ok := eng.followers[path]
+	if !ok {
+		f = &follower{path: path, lookBack: eng.catchUpLookBack}
+		if st, ok := eng.takeSavedTail(path); ok {
+			f.saved = &st
+		}
+		eng.followers[path] = f
+	}
+	if err := f.sync(); err != nil && f.file == nil && len(f.pending) == 0 {
+		return []string{}, err
+	}
+	return f.read(eng.readLimit), nil
+}
+
+// brings the follower in line with the file at the path: resumes or
+// starts on the first call, and notices truncation and rotation.
+func (f *follower) sync() error {
+	first := !f.started
+	f.started = true
+	lastRead := f.lastRead
+	f.lastRead = time.Now()
+
+	if f.file != nil {
+		if cur, err := f.file.Stat(); err == nil && cur.Size() < f.offset {
+			f.seek(0) // truncated in place (copytruncate)
+		}
+	}
+
+	info, err := os.Stat(f.path)
 	if err != nil {
-		return nil, err
+		if f.file != nil && os.IsNotExist(err) {
+			// renamed away and not recreated yet; keep the old file.
+			return nil
+		}
+		return err
 	}
-	size := info.Size()
 
-	offset, ok := lastOffsets[path]
+	if first {
+		// pick up where the previous run stopped, if the file is the same.
+		if st := f.saved; st != nil {
+			f.saved = nil
+			err := f.resume(*st)
+			if err == nil {
+				return nil
+			}
+			if f.lookBack > 0 {
+				// rotated while stopped: finish the rotated files, then
+				// read the new one from its start unless it is too old.
+				log.Printf("monitor: %s changed while stopped (%v), catching up on rotated logs", f.path, err)
+				f.pending = f.catchUp(*st, false)
+				stale := info.ModTime().Before(time.Now().Add(-f.lookBack))
+				return f.open(stale)
+			}
+			log.Printf("monitor: %s changed while stopped (%v), reading from its end", f.path, err)
+		}
+	}
 
-	// On first call, ignore historical content and start from the end.
-	if !ok {
-		lastOffsets[path] = size
-		return []string{}, nil
+	if f.file != nil && f.sameFile(info) {
+		return nil
+	}
+
+	// the file we held was rotated: finish it, and any rotation missed in
+	// between, before the new one.
+	if f.file != nil {
+		prev, _ := f.state()
+		prev.SavedAt = lastRead
+		f.pending = append(f.pending, &pendingFile{rc: f.file, lines: f.lines})
+		f.pending = append(f.pending, f.catchUp(prev, true)...)
+		f.file = nil
+	}
+
+	// a new file took the path: follow it from the start, unless this is
+	// the very first read, which starts at the end.
+	return f.open(first)
+}
+
+// reads up to limit bytes of complete lines: the rotated files first,
+// then the file at the path.
+func (f *follower) read(limit int64) []string {
+	out := []string{}
+
+	for len(f.pending) > 0 && limit > 0 {
+		p := f.pending[0]
+		lines, n, eof := p.lines.next(limit, true)
+		out = append(out, lines...)
+		limit -= n
+		if !eof {
+			return out
+		}
+		p.rc.Close()
+		f.pending = f.pending[1:]
 	}
 
-	// if the file was rotated or truncated, restart from the beginning.
-	if size < offset {
-		offset = 0
+	if f.file == nil || limit <= 0 {
+		return out
 	}
+	lines, n, _ := f.lines.next(limit, false)
+	f.offset += n
+	return append(out, lines...)
+}
 
-	// nothing new added since last read.
-	if size == offset {
-		return []string{}, nil
+// opens the file at the path, at its end when atEnd is set.
+func (f *follower) open(atEnd bool) error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
 	}
 
-	// read only the new bytes appended since the last offset.
-	toRead := size - offset
-	buf := make([]byte, toRead)
+	if f.file != nil {
+		f.file.Close()
+	}
+	f.file, f.info = file, info
+	f.id, _ = fileID(info)
+	if atEnd {
+		f.seek(info.Size())
+	} else {
+		f.seek(0)
+	}
+	return nil
+}
 
-	if _, err := f.Seek(offset, 0); err != nil {
-		return nil, err
+// reports whether info is the file currently held open.
+func (f *follower) sameFile(info os.FileInfo) bool {
+	if id, ok := fileID(info); ok && f.id != (fileKey{}) {
+		return id == f.id
 	}
+	return os.SameFile(f.info, info)
+}
 
-	n, err := f.Read(buf)
-	if n <= 0 {
-		lastOffsets[path] = size
-		return []string{}, nil
+// moves to offset, dropping any line held back.
+func (f *follower) seek(offset int64) {
+	if n, err := f.file.Seek(offset, io.SeekStart); err == nil {
+		f.offset = n
 	}
+	f.lines = newLineReader(f.file)
+}
 
-	lastOffsets[path] = offset + int64(n)
+// TailStatus is how far behind the reading of one log is.
+type TailStatus struct {
+	Path    string `json:"path"`
+	Offset  int64  `json:"offset"`
+	Size    int64  `json:"size"`
+	Backlog int64  `json:"backlog_bytes"` // written but not read yet
+	Held    int    `json:"held_bytes"`    // trailing line waiting for its newline
+	Rotated int    `json:"rotated_files"` // rotated files still to finish
+}
+
+// returns the reading status of every followed log, sorted by path.
+func (eng *Engine) TailBacklog() []TailStatus {
+	eng.followersMu.Lock()
+	defer eng.followersMu.Unlock()
 
-	data := string(buf[:n])
-	return strings.Split(data, "\n"), nil
+	out := make([]TailStatus, 0, len(eng.followers))
+	for _, f := range eng.followers {
+		st := TailStatus{Path: f.path, Rotated: len(f.pending)}
+		if f.file != nil {
+			st.Offset = f.offset
+			st.Held = f.lines.held()
+			if info, err := f.file.Stat(); err == nil {
+				st.Size = info.Size()
+				st.Backlog = max(st.Size-f.offset-int64(st.Held), 0)
+			}
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
+
+// reports whether a log has more to read than the last cycle took.
+func (eng *Engine) tailBehind() bool {
+	for _, st := range eng.TailBacklog() {
+		if st.Rotated > 0 || st.Backlog > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// logLine is one line handed to the service parsers.
+type logLine struct {
+	Time    time.Time // when it was logged, zero when unknown (plain files)
+	Program string    // program that logged it, when the source says (journal, syslog)
+	Host    string    // host that logged it, for remote sources
+	Text    string
+}
+
+// reports whether the line was logged by program, or by one of its
+// helpers named program-something (OpenSSH 9.8 logs as sshd-session).
+// Without a program from the source, the text has to mention it, as
+// syslog lines do.
+func (l logLine) from(program string) bool {
+	if l.Program != "" {
+		return l.Program == program || strings.HasPrefix(l.Program, program+"-")
+	}
+	return strings.Contains(l.Text, program)
+}
+
+// returns the new lines of the log at path; none when no path is set or
+// the file cannot be read.
+func (eng *Engine) readLogLines(path string) []logLine {
+	if path == "" {
+		return nil
+	}
+	lines, err := eng.ReadNewLines(path)
+	if err != nil {
+		return nil
+	}
+	out := make([]logLine, len(lines))
+	for i, text := range lines {
+		out[i] = logLine{Text: text}
+	}
+	return out
 }
@@ -0,0 +1,152 @@
+package monitor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"time"
+
+	"securemonitor/internal/storage"
+)
+
+// tailState records where a follower stopped, so a restart resumes there
+// instead of skipping what was logged while the daemon was down.
+type tailState struct {
+	Path     string    `json:"path"`
+	Dev      uint64    `json:"dev,omitempty"`
+	Ino      uint64    `json:"ino,omitempty"`
+	Offset   int64     `json:"offset"`
+	HeadLen  int       `json:"head_len"`
+	HeadHash string    `json:"head_hash"` // sha256 of the first HeadLen bytes
+	SavedAt  time.Time `json:"saved_at"`
+}
+
+// bytes at the start of a file hashed to recognise it; log lines carry
+// timestamps, so two files rarely share their head.
+const tailHeadSize = 1024
+
+// reads the offsets saved by a previous run. A missing file is not an
+// error: every log then starts from its end.
+func (eng *Engine) loadTailState(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var states []tailState
+	if err := json.Unmarshal(data, &states); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	eng.followersMu.Lock()
+	defer eng.followersMu.Unlock()
+	for _, st := range states {
+		eng.savedTails[st.Path] = st
+	}
+	return nil
+}
+
+// writes the offset of every followed log atomically.
+func (eng *Engine) saveTailState(path string) error {
+	eng.followersMu.Lock()
+	states := make([]tailState, 0, len(eng.followers))
+	for _, f := range eng.followers {
+		if st, ok := f.state(); ok {
+			states = append(states, st)
+		}
+	}
+	eng.followersMu.Unlock()
+
+	sort.Slice(states, func(i, j int) bool { return states[i].Path < states[j].Path })
+	data, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return err
+	}
+	return storage.WriteFileAtomic(path, append(data, '\n'))
+}
+
+// returns the position of the follower in the file it holds.
+func (f *follower) state() (tailState, bool) {
+	if f.file == nil {
+		return tailState{}, false
+	}
+	hash, n, err := headHash(f.file, f.offset)
+	if err != nil {
+		return tailState{}, false
+	}
+	return tailState{
+		Path:     f.path,
+		Dev:      f.id.Dev,
+		Ino:      f.id.Ino,
+		Offset:   f.offset,
+		HeadLen:  n,
+		HeadHash: hash,
+		SavedAt:  time.Now(),
+	}, true
+}
+
+// opens the file at the path at the saved offset, provided it is still
+// the file the offset was saved for: same device and inode where known,
+// same head, and not shorter than the offset. On failure no file is
+// held.
+func (f *follower) resume(st tailState) (err error) {
+	if err := f.open(false); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.file.Close()
+			f.file = nil
+		}
+	}()
+
+	if st.Ino != 0 && f.id != (fileKey{}) && f.id != (fileKey{Dev: st.Dev, Ino: st.Ino}) {
+		return errors.New("inode changed")
+	}
+	if size := f.info.Size(); size < st.Offset {
+		return fmt.Errorf("shrank from %d to %d bytes", st.Offset, size)
+	}
+	hash, n, err := headHash(f.file, int64(st.HeadLen))
+	if err != nil {
+		return err
+	}
+	if n != st.HeadLen || hash != st.HeadHash {
+		return errors.New("head of file changed")
+	}
+
+	f.seek(st.Offset)
+	return nil
+}
+
+// hashes the first bytes of r, up to limit and tailHeadSize. Returns the
+// hash and the number of bytes hashed.
+func headHash(r io.ReaderAt, limit int64) (string, int, error) {
+	if limit > tailHeadSize {
+		limit = tailHeadSize
+	}
+	buf := make([]byte, limit)
+	n, err := r.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return "", 0, err
+	}
+	sum := sha256.Sum256(buf[:n])
+	return hex.EncodeToString(sum[:]), n, nil
+}
+
+// returns the saved offset for path, once.
+func (eng *Engine) takeSavedTail(path string) (tailState, bool) {
+	st, ok := eng.savedTails[path]
+	if ok {
+		delete(eng.savedTails, path)
+	}
+	return st, ok
+}
@@ -0,0 +1,45 @@
+package monitor
+
+import "time"
+
+// shortest time between two scans, so a busy log waking the loop on
+// every write does not turn it into a spin.
+const minScanGap = time.Second
+
+// asks the monitor loop to scan now.
+func (eng *Engine) wakeLoop() {
+	select {
+	case eng.wake <- struct{}{}:
+	default:
+	}
+}
+
+// logWatcher wakes the monitor loop when a followed log changes.
+type logWatcher interface {
+	// starts watching the log at path.
+	Add(path string) error
+	// blocks until a watched log changed, wakeLoop was called or timeout
+	// passed.
+	Wait(timeout time.Duration)
+	Close() error
+}
+
+// pollWatcher has no change notifications: the loop runs every
+// CheckIntervalSeconds, or when woken by wakeLoop.
+type pollWatcher struct {
+	loopWake <-chan struct{}
+}
+
+func (pollWatcher) Add(path string) error { return nil }
+
+func (w pollWatcher) Wait(timeout time.Duration) {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case <-w.loopWake:
+	case <-t.C:
+	}
+}
+
+func (pollWatcher) Close() error { return nil }
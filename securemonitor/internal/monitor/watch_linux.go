@@ -0,0 +1,139 @@
+//go:build linux
+
+package monitor
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// returns the device and inode of a file.
+func fileID(info os.FileInfo) (fileKey, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileKey{}, false
+	}
+	return fileKey{Dev: uint64(st.Dev), Ino: uint64(st.Ino)}, true
+}
+
+// directory events that mean a followed file was written, rotated or
+// recreated. Directories are watched rather than files, so a file
+// created in place of a rotated one is noticed too.
+const inotifyMask = syscall.IN_MODIFY | syscall.IN_CREATE | syscall.IN_MOVED_TO |
+	syscall.IN_MOVED_FROM | syscall.IN_DELETE | syscall.IN_CLOSE_WRITE
+
+// inotifyWatcher wakes the loop when a followed log changes.
+type inotifyWatcher struct {
+	fd       int
+	wake     chan struct{}
+	loopWake <-chan struct{} // signalled by wakeLoop
+	mu       sync.Mutex
+	dirs     map[string]int          // dir -> watch descriptor
+	names    map[int]map[string]bool // watch descriptor -> followed names
+}
+
+// returns an inotify watcher, or a polling one when inotify is not
+// available. Both also wake on loopWake.
+func newLogWatcher(loopWake <-chan struct{}) logWatcher {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		log.Printf("monitor: inotify unavailable, polling logs: %v", err)
+		return pollWatcher{loopWake: loopWake}
+	}
+
+	w := &inotifyWatcher{
+		fd:       fd,
+		wake:     make(chan struct{}, 1),
+		loopWake: loopWake,
+		dirs:     make(map[string]int),
+		names:    make(map[int]map[string]bool),
+	}
+	go w.read()
+	return w
+}
+
+// watches the directory holding path for changes to it.
+func (w *inotifyWatcher) Add(path string) error {
+	dir, name := filepath.Split(filepath.Clean(path))
+	if dir == "" {
+		dir = "."
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	wd, ok := w.dirs[dir]
+	if !ok {
+		var err error
+		wd, err = syscall.InotifyAddWatch(w.fd, dir, inotifyMask)
+		if err != nil {
+			return &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+		}
+		w.dirs[dir] = wd
+		w.names[wd] = make(map[string]bool)
+	}
+	w.names[wd][name] = true
+	return nil
+}
+
+// blocks until a followed log changed, wakeLoop was called or timeout
+// passed.
+func (w *inotifyWatcher) Wait(timeout time.Duration) {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case <-w.wake:
+	case <-w.loopWake:
+	case <-t.C:
+	}
+}
+
+func (w *inotifyWatcher) Close() error {
+	return syscall.Close(w.fd)
+}
+
+// reads inotify events and signals wake for those about followed files.
+func (w *inotifyWatcher) read() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := syscall.Read(w.fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || n <= 0 {
+			return // closed
+		}
+
+		relevant := false
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			nameStart := off + syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[nameStart:nameStart+int(ev.Len)]), "\x00")
+			off = nameStart + int(ev.Len)
+
+			if ev.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				relevant = true
+				continue
+			}
+			w.mu.Lock()
+			if w.names[int(ev.Wd)][name] {
+				relevant = true
+			}
+			w.mu.Unlock()
+		}
+
+		if relevant {
+			select {
+			case w.wake <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package monitor
+
+import "os"
+
+// device and inode are not read on this platform; followers compare
+// files with os.SameFile instead.
+func fileID(info os.FileInfo) (fileKey, bool) {
+	return fileKey{}, false
+}
+
+// returns a polling watcher: there is no inotify on this platform.
+func newLogWatcher(loopWake <-chan struct{}) logWatcher {
+	return pollWatcher{loopWake: loopWake}
+}
@@ -1,49 +1,114 @@
 package monitor
 
 import (
-	"net"
+	"log"
+	"net/netip"
 	"os"
 	"strings"
+
+	"securemonitor/internal/ipaddr"
 )
 
-// isLoopback reports whether an IP belongs to loopback/private ranges.
+// Whitelist holds the addresses and CIDR ranges that must never be blocked.
+type Whitelist struct {
+	addrs    map[netip.Addr]struct{}
+	prefixes []netip.Prefix
+	invalid  []string // entries that are neither, for the caller to report
+}
+
+// isLoopback reports whether an IP belongs to loopback/private/link-local ranges.
 func isLoopback(ip string) bool {
-	p := net.ParseIP(strings.TrimSpace(ip))
-	if p == nil {
+	addr, ok := ipaddr.Parse(ip)
+	if !ok {
 		return false
 	}
-	return p.IsLoopback() || p.IsPrivate()
+	return ipaddr.IsLocal(addr)
 }
 
-// loadWhitelist loads a simple IP whitelist file where each line may contain:
-// - an IP address
+// loadWhitelist loads a simple whitelist file where each line may contain:
+// - an IPv4/IPv6 address
+// - a CIDR range (203.0.113.0/24, 2001:db8::/32)
 // - comments starting with '#'
-func loadWhitelist(path string) map[string]struct{} {
-	m := make(map[string]struct{})
+func loadWhitelist(path string) Whitelist {
 	if path == "" {
-		return m
+		return parseWhitelist(nil)
 	}
 
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return m
+		return parseWhitelist(nil)
 	}
+	return parseWhitelist(strings.Split(string(data), "\n"))
+}
 
-	lines := strings.Split(string(data), "\n")
-	for _, raw := range lines {
+// builds a Whitelist from addresses and CIDR ranges, one per entry.
+// Host ranges (/32, /128) count as their address. Comments are skipped;
+// unparsable entries are skipped and listed in invalid.
+func parseWhitelist(entries []string) Whitelist {
+	wl := Whitelist{addrs: make(map[netip.Addr]struct{})}
+	for _, raw := range entries {
 		// Support comments with '#'.
-		s := strings.Split(raw, "#")[0]
-		ip := strings.TrimSpace(s)
-		if ip == "" {
+		s := strings.TrimSpace(strings.Split(raw, "#")[0])
+		if s == "" {
+			continue
+		}
+
+		// CanonicalTarget masks ranges and collapses host ranges to the
+		// plain address.
+		target := ipaddr.CanonicalTarget(s)
+		if p, err := netip.ParsePrefix(target); err == nil {
+			wl.prefixes = append(wl.prefixes, p)
 			continue
 		}
-		m[ip] = struct{}{}
+		if addr, ok := ipaddr.Parse(target); ok {
+			wl.addrs[addr] = struct{}{}
+			continue
+		}
+		wl.invalid = append(wl.invalid, s)
 	}
-	return m
+	return wl
 }
 
-// isWhitelisted returns true if the IP is present in the whitelist map.
-func isWhitelisted(ip string, wl map[string]struct{}) bool {
-	_, ok := wl[strings.TrimSpace(ip)]
-	return ok
+// logs the entries of the whitelist file that did not parse, once each.
+// Callers must hold enforceMu.
+func (eng *Engine) warnInvalidWhitelist(wl Whitelist) {
+	for _, s := range wl.invalid {
+		if eng.whitelistWarned[s] {
+			continue
+		}
+		eng.whitelistWarned[s] = true
+		log.Printf("monitor: ignoring whitelist entry %q, not an address or CIDR range", s)
+	}
+}
+
+// isWhitelisted returns true if the IP is listed or inside a listed range.
+func isWhitelisted(ip string, wl Whitelist) bool {
+	addr, ok := ipaddr.Parse(ip)
+	if !ok {
+		return false
+	}
+	if _, ok := wl.addrs[addr]; ok {
+		return true
+	}
+	for _, p := range wl.prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// reports whether any whitelisted address or range overlaps p.
+func (wl Whitelist) overlaps(p netip.Prefix) bool {
+	for addr := range wl.addrs {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	for _, q := range wl.prefixes {
+		if p.Overlaps(q) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,46 @@
+package monitor
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestWhitelist(t *testing.T) {
+	wl := parseWhitelist([]string{
+		"# office",
+		"198.51.100.7",
+		"203.0.113.7/32 # host range",
+		"2001:db8::7/128",
+		"192.0.2.9/24",
+		"2001:db8:1::/48",
+		"::ffff:198.51.100.200",
+		"",
+		"not-an-address",
+		"10.0.0.0/33",
+	})
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"198.51.100.7", true},
+		{"198.51.100.8", false},
+		{"203.0.113.7", true},
+		{"203.0.113.8", false},
+		{"2001:db8::7", true},
+		{"2001:db8::8", false},
+		{"192.0.2.200", true},
+		{"2001:db8:1::42", true},
+		{"198.51.100.200", true},
+		{"garbage", false},
+	}
+	for _, tt := range tests {
+		if got := isWhitelisted(tt.ip, wl); got != tt.want {
+			t.Errorf("isWhitelisted(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+
+	if want := []string{"not-an-address", "10.0.0.0/33"}; !slices.Equal(wl.invalid, want) {
+		t.Errorf("invalid = %q, want %q", wl.invalid, want)
+	}
+}
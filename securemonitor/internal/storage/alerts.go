@@ -2,37 +2,88 @@ package storage
 
 // represents a security alert for the dashboard.
 type Alert struct {
+	ID        uint64 `json:"id"` // increasing, used as pagination cursor
 	Timestamp string `json:"timestamp"`
 	Service   string `json:"service"`
 	IP        string `json:"ip,omitempty"`
+	Host      string `json:"host,omitempty"` // hosts that reported the events (syslog)
 	Country   string `json:"country,omitempty"`
 	Severity  string `json:"severity"`
 	Message   string `json:"message"`
+	DryRun    bool   `json:"dry_run,omitempty"` // shadow decision, firewall untouched
 }
 
-// alerts buffer (ring buffer).
-var (
-	alerts        []Alert
-	maxAlertsSize = 100
-)
+// size of the alerts buffer (ring buffer).
+const maxAlertsSize = 100
 
-//  appends an alert to the buffer, trimming if needed.
-func AddAlert(a Alert) {
-	storeMu.Lock()
-	defer storeMu.Unlock()
+// stamps the alert with the next ID and appends it to the buffer,
+// trimming if needed. Returns the stored alert.
+func (m *MemoryStore) AddAlert(a Alert) Alert {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	alerts = append(alerts, a)
-	if len(alerts) > maxAlertsSize {
-		alerts = alerts[len(alerts)-maxAlertsSize:]
+	a.ID = m.nextAlertID
+	m.nextAlertID++
+
+	m.alerts = append(m.alerts, a)
+	if len(m.alerts) > maxAlertsSize {
+		m.alerts = m.alerts[len(m.alerts)-maxAlertsSize:]
 	}
+	return a
 }
 
 // returns a snapshot of the alerts in memory.
-func GetAlerts() []Alert {
-	storeMu.Lock()
-	defer storeMu.Unlock()
+func (m *MemoryStore) GetAlerts() []Alert {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	out := make([]Alert, len(alerts))
-	copy(out, alerts)
+	out := make([]Alert, len(m.alerts))
+	copy(out, m.alerts)
 	return out
 }
+
+// streams every buffered alert matching q (Limit is ignored), oldest
+// first, to fn. Stops at the first error returned by fn.
+func (m *MemoryStore) ScanAlerts(q AlertQuery, fn func(Alert) error) error {
+	q.IP = canonicalFilterIP(q.IP)
+	for _, a := range m.GetAlerts() {
+		if !q.matches(a) {
+			continue
+		}
+		if err := fn(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returns the newest q.Limit buffered alerts matching q, oldest first,
+// and the cursor for the page before them (0 when there is none).
+func (m *MemoryStore) QueryAlerts(q AlertQuery) ([]Alert, uint64, error) {
+	return pageAlerts(m.ScanAlerts, q)
+}
+
+// collects the newest q.Limit alerts streamed by scan, oldest first, and
+// the cursor for the page before them.
+func pageAlerts(scan func(AlertQuery, func(Alert) error) error, q AlertQuery) ([]Alert, uint64, error) {
+	var page []Alert
+	more := false
+
+	err := scan(q, func(a Alert) error {
+		page = append(page, a)
+		if q.Limit > 0 && len(page) > q.Limit {
+			page = page[1:]
+			more = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var next uint64
+	if more && len(page) > 0 {
+		next = page[0].ID
+	}
+	return page, next, nil
+}
@@ -0,0 +1,320 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"securemonitor/internal/ipaddr"
+)
+
+// AlertStoreOptions configures the on-disk alert history.
+type AlertStoreOptions struct {
+	Dir      string        // directory holding the segment files
+	MaxAge   time.Duration // segments last written before this are deleted; 0 = keep
+	MaxBytes int64         // oldest segments are deleted above this total; 0 = no limit
+}
+
+// AlertQuery selects alerts from the history. Zero fields match
+// everything.
+type AlertQuery struct {
+	Since    time.Time
+	Until    time.Time
+	Service  string
+	Severity string
+	IP       string
+	Host     string // one of the reporting hosts
+	Before   uint64 // only alerts with a lower ID (pagination cursor)
+	Limit    int    // newest Limit matches; 0 = all
+}
+
+// the alert history is a sequence of append-only JSON-lines segments named
+// after the ID of their first alert (alerts-00000000000000000042.jsonl),
+// so they sort by ID and retention drops whole files.
+type alertSegments struct {
+	mu   sync.Mutex
+	opts AlertStoreOptions
+
+	file      *os.File
+	fileSize  int64
+	openedAt  time.Time
+	lastPrune time.Time
+}
+
+const (
+	alertSegmentPrefix = "alerts-"
+	alertSegmentSuffix = ".jsonl"
+	alertSegmentMax    = 8 << 20 // rotate after 8 MiB or a day
+)
+
+// opens (creating if needed) the alert history in opts.Dir and returns
+// it with the ID of the newest stored alert.
+func openAlertSegments(opts AlertStoreOptions) (*alertSegments, uint64, error) {
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, 0, err
+	}
+
+	s := &alertSegments{opts: opts}
+	segs, err := s.segments()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// continue the ID sequence after the newest stored alert.
+	var last uint64
+	if len(segs) > 0 {
+		err := scanSegment(segs[len(segs)-1], func(a Alert) error {
+			if a.ID > last {
+				last = a.ID
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		if id, ok := segmentFirstID(segs[len(segs)-1]); ok && id > last {
+			last = id - 1
+		}
+	}
+
+	s.mu.Lock()
+	s.prune(time.Now())
+	s.mu.Unlock()
+
+	return s, last, nil
+}
+
+// returns the segment files sorted oldest first.
+func (s *alertSegments) segments() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(s.opts.Dir, alertSegmentPrefix+"*"+alertSegmentSuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// extracts the first alert ID from a segment file name.
+func segmentFirstID(path string) (uint64, bool) {
+	name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), alertSegmentPrefix), alertSegmentSuffix)
+	id, err := strconv.ParseUint(name, 10, 64)
+	return id, err == nil
+}
+
+// appends one alert, rotating and pruning segments as needed.
+func (s *alertSegments) append(a Alert) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.file != nil && (s.fileSize >= alertSegmentMax || now.Sub(s.openedAt) >= 24*time.Hour) {
+		s.file.Close()
+		s.file = nil
+	}
+	if s.file == nil {
+		path := filepath.Join(s.opts.Dir, fmt.Sprintf("%s%020d%s", alertSegmentPrefix, a.ID, alertSegmentSuffix))
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			log.Printf("storage: cannot open alert segment: %v", err)
+			return
+		}
+		s.file, s.fileSize, s.openedAt = f, 0, now
+	}
+
+	data, err := json.Marshal(a)
+	if err != nil {
+		return
+	}
+	n, err := s.file.Write(append(data, '\n'))
+	s.fileSize += int64(n)
+	if err != nil {
+		log.Printf("storage: cannot append alert: %v", err)
+	}
+
+	if now.Sub(s.lastPrune) >= time.Minute {
+		s.prune(now)
+	}
+}
+
+// closes the segment being written.
+func (s *alertSegments) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// deletes segments past the retention age, then the oldest ones while the
+// history is over its size budget. The segment being written is kept.
+// Callers must hold s.mu.
+func (s *alertSegments) prune(now time.Time) {
+	s.lastPrune = now
+
+	segs, err := s.segments()
+	if err != nil {
+		return
+	}
+	current := ""
+	if s.file != nil {
+		current = s.file.Name()
+	}
+
+	type segInfo struct {
+		path string
+		size int64
+	}
+	var kept []segInfo
+	var total int64
+	for _, path := range segs {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if path != current && s.opts.MaxAge > 0 && now.Sub(info.ModTime()) > s.opts.MaxAge {
+			os.Remove(path)
+			continue
+		}
+		kept = append(kept, segInfo{path, info.Size()})
+		total += info.Size()
+	}
+
+	for _, seg := range kept {
+		if s.opts.MaxBytes <= 0 || total <= s.opts.MaxBytes {
+			break
+		}
+		if seg.path == current {
+			continue
+		}
+		os.Remove(seg.path)
+		total -= seg.size
+	}
+}
+
+// reports whether the alert satisfies the filters of q (Limit aside).
+func (q AlertQuery) matches(a Alert) bool {
+	if q.Before > 0 && a.ID >= q.Before {
+		return false
+	}
+	if q.Service != "" && !strings.EqualFold(a.Service, q.Service) {
+		return false
+	}
+	if q.Severity != "" && !strings.EqualFold(a.Severity, q.Severity) {
+		return false
+	}
+	if q.IP != "" && a.IP != q.IP {
+		return false
+	}
+	if q.Host != "" && !slices.Contains(strings.Split(a.Host, ","), q.Host) {
+		return false
+	}
+	if !q.Since.IsZero() || !q.Until.IsZero() {
+		ts, err := time.Parse(time.RFC3339, a.Timestamp)
+		if err != nil {
+			return false
+		}
+		if !q.Since.IsZero() && ts.Before(q.Since) {
+			return false
+		}
+		if !q.Until.IsZero() && ts.After(q.Until) {
+			return false
+		}
+	}
+	return true
+}
+
+// streams every stored alert matching q (Limit is ignored), oldest first,
+// to fn. Stops at the first error returned by fn.
+func (s *alertSegments) scan(q AlertQuery, fn func(Alert) error) error {
+	q.IP = canonicalFilterIP(q.IP)
+
+	s.mu.Lock()
+	segs, err := s.segments()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, path := range segs {
+		// segments starting at or after the cursor hold nothing older.
+		if q.Before > 0 {
+			if first, ok := segmentFirstID(path); ok && first >= q.Before {
+				break
+			}
+		}
+		// segments last written before Since hold nothing newer.
+		if !q.Since.IsZero() {
+			if info, err := os.Stat(path); err == nil && info.ModTime().Before(q.Since) {
+				continue
+			}
+		}
+
+		err := scanSegment(path, func(a Alert) error {
+			if !q.matches(a) {
+				return nil
+			}
+			return fn(a)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodes one segment line by line. Lines cut short by a crash are
+// skipped.
+func scanSegment(path string, fn func(Alert) error) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil // pruned meanwhile
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			var a Alert
+			if json.Unmarshal(line, &a) == nil {
+				if ferr := fn(a); ferr != nil {
+					return ferr
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// canonicalizes an IP filter so it matches the stored spelling.
+func canonicalFilterIP(ip string) string {
+	if ip == "" {
+		return ""
+	}
+	if c := ipaddr.CanonicalTarget(ip); c != "" {
+		return c
+	}
+	return strings.TrimSpace(ip)
+}
@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditRecord is one operator action taken through the API.
+type AuditRecord struct {
+	ID        uint64    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Actor     string    `json:"actor"`  // identity set by the auth proxy, or "anonymous"
+	Source    string    `json:"source"` // address the request came from
+	Action    string    `json:"action"` // unblock, block, whitelist_add, whitelist_remove, config_change, simulate
+	Target    string    `json:"target,omitempty"`
+	Before    any       `json:"before,omitempty"`
+	After     any       `json:"after,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// AuditQuery selects audit records. Zero fields match everything.
+type AuditQuery struct {
+	Since  time.Time
+	Until  time.Time
+	Actor  string
+	Action string
+	Target string
+	Before uint64 // only records with a lower ID (pagination cursor)
+	Limit  int    // newest Limit matches; 0 = all
+}
+
+// stamps a record and keeps it in memory.
+func (m *MemoryStore) AddAudit(rec AuditRecord) AuditRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec.ID = m.nextAuditID
+	m.nextAuditID++
+	if rec.Timestamp.IsZero() {
+		rec.Timestamp = time.Now()
+	}
+	m.audit = append(m.audit, rec)
+	return rec
+}
+
+// returns the newest q.Limit records matching q, oldest first, and the
+// cursor for the page before them (0 when there is none).
+func (m *MemoryStore) QueryAudit(q AuditQuery) ([]AuditRecord, uint64, error) {
+	m.mu.Lock()
+	records := append([]AuditRecord(nil), m.audit...)
+	m.mu.Unlock()
+
+	return pageAudit(func(fn func(AuditRecord) error) error {
+		for _, rec := range records {
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, q)
+}
+
+// the on-disk audit trail is a single append-only JSON-lines file; it is
+// never rotated or pruned by the daemon.
+type auditLog struct {
+	mu     sync.Mutex
+	file   *os.File
+	path   string
+	nextID uint64
+}
+
+// opens (creating if needed) the audit trail at path.
+func openAuditLog(path string) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	// continue the ID sequence after the last record.
+	l := &auditLog{file: f, path: path, nextID: 1}
+	err = scanAuditFile(path, func(rec AuditRecord) error {
+		if rec.ID >= l.nextID {
+			l.nextID = rec.ID + 1
+		}
+		return nil
+	})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// stamps and appends a record. The record is synced to disk before add
+// returns.
+func (l *auditLog) add(rec AuditRecord) AuditRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rec.ID = l.nextID
+	l.nextID++
+	if rec.Timestamp.IsZero() {
+		rec.Timestamp = time.Now()
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("storage: cannot encode audit record: %v", err)
+		return rec
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		log.Printf("storage: cannot append audit record: %v", err)
+		return rec
+	}
+	if err := l.file.Sync(); err != nil {
+		log.Printf("storage: cannot sync audit log: %v", err)
+	}
+	return rec
+}
+
+// returns the newest q.Limit records in the file matching q, oldest
+// first, and the cursor for the page before them.
+func (l *auditLog) query(q AuditQuery) ([]AuditRecord, uint64, error) {
+	return pageAudit(func(fn func(AuditRecord) error) error {
+		return scanAuditFile(l.path, fn)
+	}, q)
+}
+
+func (l *auditLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+func (q AuditQuery) matches(rec AuditRecord) bool {
+	if q.Before > 0 && rec.ID >= q.Before {
+		return false
+	}
+	if !q.Since.IsZero() && rec.Timestamp.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && rec.Timestamp.After(q.Until) {
+		return false
+	}
+	if q.Actor != "" && rec.Actor != q.Actor {
+		return false
+	}
+	if q.Action != "" && !strings.EqualFold(rec.Action, q.Action) {
+		return false
+	}
+	if q.Target != "" && rec.Target != q.Target {
+		return false
+	}
+	return true
+}
+
+// collects the newest q.Limit records matching q streamed by scan,
+// oldest first, and the cursor for the page before them.
+func pageAudit(scan func(func(AuditRecord) error) error, q AuditQuery) ([]AuditRecord, uint64, error) {
+	var page []AuditRecord
+	more := false
+
+	err := scan(func(rec AuditRecord) error {
+		if !q.matches(rec) {
+			return nil
+		}
+		page = append(page, rec)
+		if q.Limit > 0 && len(page) > q.Limit {
+			page = page[1:]
+			more = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var next uint64
+	if more && len(page) > 0 {
+		next = page[0].ID
+	}
+	return page, next, nil
+}
+
+// decodes the audit file line by line, skipping unreadable lines.
+func scanAuditFile(path string, fn func(AuditRecord) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for sc.Scan() {
+		var rec AuditRecord
+		if json.Unmarshal(sc.Bytes(), &rec) != nil {
+			continue
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
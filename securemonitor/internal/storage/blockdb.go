@@ -0,0 +1,248 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"securemonitor/internal/ipaddr"
+)
+
+// where a block decision came from (BlockedEntry.Source).
+const (
+	SourceMonitor   = "monitor"   // a service strategy crossed its threshold
+	SourceAggregate = "aggregate" // subnet aggregation
+	SourceMigrated  = "migrated"  // imported from the legacy blocked_ips file
+)
+
+// one line of the block database: a blocked entry, a rate limit, a lifted
+// block, or the strike history of an address, so repeat offenders keep
+// their strikes after the block itself was lifted.
+type dbRecord struct {
+	Block   *BlockedEntry `json:"block,omitempty"`
+	Limit   *BlockedEntry `json:"limit,omitempty"`
+	Past    *PastBlock    `json:"past,omitempty"`
+	Strikes *strikeRecord `json:"strikes,omitempty"`
+}
+
+type strikeRecord struct {
+	IP    string      `json:"ip"`
+	Times []time.Time `json:"times"`
+
+	// databases written before strike times were kept only hold a count.
+	Count int `json:"count,omitempty"`
+}
+
+// restores blocks, limits and strike counts from the JSON-lines database
+// at path. When the database does not exist yet, the legacy
+// one-IP-per-line file is imported instead and the database written, so
+// the migration happens once. Unreadable lines are skipped.
+func (m *MemoryStore) loadBlockDB(path, legacyPath string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		n := m.loadLegacyBlocked(legacyPath)
+		if n == 0 {
+			return nil
+		}
+		log.Printf("storage: migrated %d blocks from %s to %s", n, legacyPath, path)
+		return m.saveBlockDB(path)
+	}
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		raw := bytes.TrimSpace(sc.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+
+		var rec dbRecord
+		if err := json.Unmarshal(raw, &rec); err != nil {
+			log.Printf("storage: %s:%d: skipping unreadable record: %v", path, line, err)
+			continue
+		}
+
+		if rec.Strikes != nil {
+			if ip := ipaddr.CanonicalTarget(rec.Strikes.IP); ip != "" {
+				times := rec.Strikes.Times
+				// a bare count gets dated now, so decay starts over.
+				for len(times) < rec.Strikes.Count {
+					times = append(times, now)
+				}
+				sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+				m.strikeHistory[ip] = times
+			}
+		}
+
+		if rec.Past != nil {
+			if p := *rec.Past; ipaddr.CanonicalTarget(p.IP) != "" {
+				p.IP = ipaddr.CanonicalTarget(p.IP)
+				m.addPastBlock(p)
+			}
+		}
+
+		if rec.Block != nil {
+			e := *rec.Block
+			e.IP = ipaddr.CanonicalTarget(e.IP)
+			if e.IP == "" {
+				continue
+			}
+			// changes that were in flight at shutdown are settled by the
+			// startup reconciliation and the auto-unblock.
+			if e.State == BlockPending || e.State == BlockRemoving || e.State == "" {
+				e.State = BlockActive
+			}
+			if e.Strikes < 1 {
+				e.Strikes = 1
+			}
+			m.blocked[e.IP] = e
+		}
+
+		if rec.Limit != nil {
+			e := *rec.Limit
+			e.IP = ipaddr.CanonicalTarget(e.IP)
+			if e.IP == "" {
+				continue
+			}
+			if e.State == BlockPending || e.State == BlockRemoving || e.State == "" {
+				e.State = BlockActive
+			}
+			m.limited[e.IP] = e
+		}
+	}
+	return sc.Err()
+}
+
+// writes every block, limit, lifted block and live strike history to the
+// database at path. The file is replaced atomically (temp file, fsync, rename), so a crash leaves
+// either the previous or the new version, never a truncated one.
+func (m *MemoryStore) saveBlockDB(path string) error {
+	m.mu.Lock()
+	entries := make([]BlockedEntry, 0, len(m.blocked))
+	for _, e := range m.blocked {
+		entries = append(entries, e)
+	}
+	limits := make([]BlockedEntry, 0, len(m.limited))
+	for _, e := range m.limited {
+		limits = append(limits, e)
+	}
+	now := time.Now()
+	strikes := make([]strikeRecord, 0, len(m.strikeHistory))
+	for ip := range m.strikeHistory {
+		// decayed histories are dropped rather than written; the others
+		// keep their forgiven strikes, decay is worked out again on load.
+		if len(m.liveStrikes(ip, now)) > 0 {
+			times := m.windowStrikes(ip, now)
+			strikes = append(strikes, strikeRecord{IP: ip, Times: append([]time.Time(nil), times...)})
+		}
+	}
+	past := m.livePastBlocks(now)
+	m.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].IP < entries[j].IP })
+	sort.Slice(limits, func(i, j int) bool { return limits[i].IP < limits[j].IP })
+	sort.Slice(strikes, func(i, j int) bool { return strikes[i].IP < strikes[j].IP })
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for i := range entries {
+		if err := enc.Encode(dbRecord{Block: &entries[i]}); err != nil {
+			return err
+		}
+	}
+	for i := range limits {
+		if err := enc.Encode(dbRecord{Limit: &limits[i]}); err != nil {
+			return err
+		}
+	}
+	for i := range past {
+		if err := enc.Encode(dbRecord{Past: &past[i]}); err != nil {
+			return err
+		}
+	}
+	for i := range strikes {
+		if err := enc.Encode(dbRecord{Strikes: &strikes[i]}); err != nil {
+			return err
+		}
+	}
+
+	return WriteFileAtomic(path, buf.Bytes())
+}
+
+// replaces path with data through a synced temp file in the same
+// directory and a rename, so readers never see a partial file.
+func WriteFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replace %s: %w", path, err)
+	}
+	return nil
+}
+
+// imports the legacy file (one IP per line). It holds neither timestamps
+// nor strikes, so migrated blocks start now with one strike. Returns the
+// number of blocks imported.
+func (m *MemoryStore) loadLegacyBlocked(path string) int {
+	if path == "" {
+		return 0
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	n := 0
+	for _, raw := range strings.Split(string(data), "\n") {
+		// older files may hold non-canonical spellings; skip garbage.
+		ip := ipaddr.CanonicalTarget(raw)
+		if ip == "" {
+			continue
+		}
+
+		m.blocked[ip] = BlockedEntry{
+			IP:        ip,
+			BlockedAt: now,
+			Strikes:   m.addStrike(ip, now),
+			Source:    SourceMigrated,
+			State:     BlockActive,
+		}
+		n++
+	}
+	return n
+}
@@ -1,9 +1,7 @@
 package storage
 
 import (
-	"os"
 	"strings"
-	"sync"
 	"time"
 )
 
@@ -13,128 +11,176 @@ type BlockedEntry struct {
 	IP        string    `json:"ip"`
 	BlockedAt time.Time `json:"blocked_at"`
 	Strikes   int       `json:"strikes"`
-}
+	Service   string    `json:"service,omitempty"`
 
-var (
-	storeMu      sync.Mutex
-	blockedIPs   = make(map[string]BlockedEntry)
-	strikeCounts = make(map[string]int)
-)
+	// Scope is "host" (all traffic denied) or "service" (only Ports).
+	Scope string   `json:"scope,omitempty"`
+	Ports []string `json:"ports,omitempty"`
 
-// restores blocked IPs from a file (one IP per line).
-// Since the file does not store timestamps nor strikes, we use time.Now()
-func LoadBlockedFromFile(path string) {
-	storeMu.Lock()
-	defer storeMu.Unlock()
+	// State tracks the firewall side of the block (see Block* constants).
+	State string `json:"state,omitempty"`
 
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return
-	}
+	ExpiresAt time.Time `json:"expires_at"`       // zero = until unblocked
+	Reason    string    `json:"reason,omitempty"` // e.g. "total fails=5, threshold=3"
+	Source    string    `json:"source,omitempty"` // see Source* constants
+}
 
-	now := time.Now()
-	lines := strings.Split(string(data), "\n")
-	for _, raw := range lines {
-		ip := strings.TrimSpace(raw)
-		if ip == "" {
-			continue
-		}
+// lifecycle of a blocked entry while its firewall change is queued.
+const (
+	BlockPending  = "pending"  // block queued, not applied yet
+	BlockActive   = "active"   // rule in place
+	BlockFailed   = "failed"   // block or unblock ran out of retries
+	BlockRemoving = "removing" // unblock queued
+)
 
-		current := strikeCounts[ip]
-		if current < 1 {
-			current = 1
+// folds the ports of b into a: a host-wide block absorbs any scoped one.
+func mergeScope(a, b BlockedEntry) BlockedEntry {
+	if len(a.Ports) == 0 || len(b.Ports) == 0 {
+		a.Scope = "host"
+		a.Ports = nil
+		return a
+	}
+	for _, p := range b.Ports {
+		found := false
+		for _, q := range a.Ports {
+			if p == q {
+				found = true
+				break
+			}
 		}
-		strikeCounts[ip] = current
-
-		blockedIPs[ip] = BlockedEntry{
-			IP:        ip,
-			BlockedAt: now,
-			Strikes:   current,
+		if !found {
+			a.Ports = append(a.Ports, p)
 		}
 	}
+	a.Scope = "service"
+	return a
 }
 
-// persists only the IPs (one per line) to disk.
-func SaveBlockedToFile(path string) {
-	storeMu.Lock()
-	defer storeMu.Unlock()
+// AddBlocked marks e.IP as blocked by e.Service with e's scope, filling
+// in BlockedAt and Strikes. Blocking an IP that is already blocked only
+// widens its scope; expiry, reason and source stay those of the original
+// block. Returns the stored entry.
+func (m *MemoryStore) AddBlocked(e BlockedEntry) BlockedEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	var b strings.Builder
-	for ip := range blockedIPs {
-		b.WriteString(ip)
-		b.WriteByte('\n')
+	ip := strings.TrimSpace(e.IP)
+	if ip == "" {
+		return e
+	}
+	e.IP = ip
+	if len(e.Ports) == 0 {
+		e.Scope = "host"
+	} else {
+		e.Scope = "service"
 	}
 
-	_ = os.WriteFile(path, []byte(b.String()), 0o644)
+	if entry, ok := m.blocked[ip]; ok {
+		if entry.Strikes <= 0 {
+			entry.Strikes = len(m.liveStrikes(ip, time.Now()))
+			if entry.Strikes < 1 {
+				entry.Strikes = 1
+			}
+		}
+		entry = mergeScope(entry, e)
+		if e.State != "" {
+			entry.State = e.State
+		}
+		m.blocked[ip] = entry
+		return entry
+	}
+
+	e.BlockedAt = time.Now()
+	e.Strikes = m.addStrike(ip, e.BlockedAt)
+	m.blocked[ip] = e
+	return e
 }
 
-// AddBlocked marks an IP as blocked.
-func AddBlocked(ip string) {
-	storeMu.Lock()
-	defer storeMu.Unlock()
+// moves an entry to a new state, only if it is currently in one of from
+// (any state when from is empty). Reports whether the entry changed.
+func (m *MemoryStore) SetBlockState(ip, state string, from ...string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
 	ip = strings.TrimSpace(ip)
-	if ip == "" {
-		return
+	entry, ok := m.blocked[ip]
+	if !ok {
+		return false
 	}
-
-	if entry, ok := blockedIPs[ip]; ok {
-		if entry.Strikes <= 0 {
-			curr := strikeCounts[ip]
-			if curr < 1 {
-				curr = 1
+	if len(from) > 0 {
+		allowed := false
+		for _, f := range from {
+			if entry.State == f {
+				allowed = true
+				break
 			}
-			entry.Strikes = curr
-			blockedIPs[ip] = entry
 		}
-		return
+		if !allowed {
+			return false
+		}
 	}
 
-	prevStrikes := strikeCounts[ip]
-	if prevStrikes < 0 {
-		prevStrikes = 0
-	}
-	newStrikes := prevStrikes + 1
-	if newStrikes <= 0 {
-		newStrikes = 1
-	}
+	entry.State = state
+	m.blocked[ip] = entry
+	return true
+}
 
-	strikeCounts[ip] = newStrikes
+// returns the blocked entry for the IP, if any.
+func (m *MemoryStore) GetBlocked(ip string) (BlockedEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	blockedIPs[ip] = BlockedEntry{
-		IP:        ip,
-		BlockedAt: time.Now(),
-		Strikes:   newStrikes,
+	entry, ok := m.blocked[strings.TrimSpace(ip)]
+	return entry, ok
+}
+
+// returns the strike count the IP will have once AddBlocked is called,
+// so callers can size the ban before the block is applied. Only strikes
+// still counting under the StrikePolicy are considered.
+func (m *MemoryStore) NextStrikes(ip string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ip = strings.TrimSpace(ip)
+	if entry, ok := m.blocked[ip]; ok && entry.Strikes > 0 {
+		return entry.Strikes
 	}
+
+	return len(m.liveStrikes(ip, time.Now())) + 1
 }
 
-//  removes an IP from the in-memory blocked map.
-func RemoveBlocked(ip string) {
-	storeMu.Lock()
-	defer storeMu.Unlock()
+// removes an IP from the in-memory blocked map, keeping the lifted
+// block in its history.
+func (m *MemoryStore) RemoveBlocked(ip string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	delete(blockedIPs, strings.TrimSpace(ip))
+	ip = strings.TrimSpace(ip)
+	if entry, ok := m.blocked[ip]; ok {
+		m.addPastBlock(PastBlock{BlockedEntry: entry, LiftedAt: time.Now()})
+	}
+	delete(m.blocked, ip)
 }
 
 //  returns only the IPs (for the /api/blocked handler).
-func ListBlocked() []string {
-	storeMu.Lock()
-	defer storeMu.Unlock()
+func (m *MemoryStore) ListBlocked() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	ips := make([]string, 0, len(blockedIPs))
-	for ip := range blockedIPs {
+	ips := make([]string, 0, len(m.blocked))
+	for ip := range m.blocked {
 		ips = append(ips, ip)
 	}
 	return ips
 }
 
 //  returns IP + BlockedAt + Strikes for auto-unblock logic.
-func ListBlockedEntries() []BlockedEntry {
-	storeMu.Lock()
-	defer storeMu.Unlock()
+func (m *MemoryStore) ListBlockedEntries() []BlockedEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	out := make([]BlockedEntry, 0, len(blockedIPs))
-	for _, entry := range blockedIPs {
+	out := make([]BlockedEntry, 0, len(m.blocked))
+	for _, entry := range m.blocked {
 		out = append(out, entry)
 	}
 	return out
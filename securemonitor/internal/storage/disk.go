@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"errors"
+	"log"
+	"sync"
+)
+
+// DiskOptions says where a DiskStore keeps its files. Empty fields keep
+// that part in memory only.
+type DiskOptions struct {
+	BlockDB       string            // block database (JSON lines), written by Save
+	LegacyBlocked string            // one-IP-per-line file imported when BlockDB does not exist yet
+	Alerts        AlertStoreOptions // alert history segments
+	AuditLog      string            // append-only audit trail
+}
+
+// DiskStore is a Store that survives restarts: blocks and strikes live in
+// memory and are written to the block database by Save, while alerts and
+// audit records are appended to disk as they come.
+type DiskStore struct {
+	*MemoryStore
+
+	blockDB string
+
+	alertMu sync.Mutex // keeps alert IDs in file order
+	alerts  *alertSegments
+	audit   *auditLog
+}
+
+// opens the files named in opts, loading the block database (migrating
+// the legacy file) and continuing the alert and audit ID sequences. A
+// broken alert history only disables it.
+func OpenDiskStore(opts DiskOptions) (*DiskStore, error) {
+	d := &DiskStore{MemoryStore: NewMemoryStore(), blockDB: opts.BlockDB}
+
+	if opts.BlockDB != "" {
+		if err := d.loadBlockDB(opts.BlockDB, opts.LegacyBlocked); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Alerts.Dir != "" {
+		alerts, last, err := openAlertSegments(opts.Alerts)
+		if err != nil {
+			log.Printf("storage: alert history disabled: %v", err)
+		} else {
+			d.alerts = alerts
+			d.nextAlertID = last + 1
+		}
+	}
+
+	if opts.AuditLog != "" {
+		audit, err := openAuditLog(opts.AuditLog)
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+		d.audit = audit
+	}
+	return d, nil
+}
+
+// appends an alert to the buffer and to the on-disk history.
+func (d *DiskStore) AddAlert(a Alert) Alert {
+	d.alertMu.Lock()
+	defer d.alertMu.Unlock()
+
+	a = d.MemoryStore.AddAlert(a)
+	if d.alerts != nil {
+		d.alerts.append(a)
+	}
+	return a
+}
+
+// streams every alert matching q (Limit is ignored), oldest first, to fn
+// from the on-disk history.
+func (d *DiskStore) ScanAlerts(q AlertQuery, fn func(Alert) error) error {
+	if d.alerts == nil {
+		return d.MemoryStore.ScanAlerts(q, fn)
+	}
+	return d.alerts.scan(q, fn)
+}
+
+// returns the newest q.Limit alerts matching q, oldest first, and the
+// cursor for the page before them (0 when there is none).
+func (d *DiskStore) QueryAlerts(q AlertQuery) ([]Alert, uint64, error) {
+	return pageAlerts(d.ScanAlerts, q)
+}
+
+// stamps and appends a record to the audit trail, synced before
+// AddAudit returns.
+func (d *DiskStore) AddAudit(rec AuditRecord) AuditRecord {
+	if d.audit == nil {
+		return d.MemoryStore.AddAudit(rec)
+	}
+	return d.audit.add(rec)
+}
+
+// returns the newest q.Limit audit records matching q, oldest first, and
+// the cursor for the page before them (0 when there is none).
+func (d *DiskStore) QueryAudit(q AuditQuery) ([]AuditRecord, uint64, error) {
+	if d.audit == nil {
+		return d.MemoryStore.QueryAudit(q)
+	}
+	return d.audit.query(q)
+}
+
+// writes the block database.
+func (d *DiskStore) Save() error {
+	if d.blockDB == "" {
+		return nil
+	}
+	return d.saveBlockDB(d.blockDB)
+}
+
+// closes the alert history and the audit trail.
+func (d *DiskStore) Close() error {
+	var errs []error
+	if d.alerts != nil {
+		errs = append(errs, d.alerts.close())
+	}
+	if d.audit != nil {
+		errs = append(errs, d.audit.close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// records an offence by e.IP under a rate limit and returns the entry.
+// A new entry starts at one offence; a repeated one counts another and
+// widens the scope like AddBlocked.
+func (m *MemoryStore) AddLimited(e BlockedEntry) BlockedEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ip := strings.TrimSpace(e.IP)
+	if ip == "" {
+		return e
+	}
+	e.IP = ip
+	if len(e.Ports) == 0 {
+		e.Scope = "host"
+	} else {
+		e.Scope = "service"
+	}
+
+	if entry, ok := m.limited[ip]; ok {
+		entry = mergeScope(entry, e)
+		entry.Strikes++
+		if e.State != "" {
+			entry.State = e.State
+		}
+		m.limited[ip] = entry
+		return entry
+	}
+
+	e.BlockedAt = time.Now()
+	e.Strikes = 1
+	m.limited[ip] = e
+	return e
+}
+
+// moves a limit to a new state, only if it is currently in one of from
+// (any state when from is empty). Reports whether the entry changed.
+func (m *MemoryStore) SetLimitState(ip, state string, from ...string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ip = strings.TrimSpace(ip)
+	entry, ok := m.limited[ip]
+	if !ok {
+		return false
+	}
+	if len(from) > 0 {
+		allowed := false
+		for _, f := range from {
+			if entry.State == f {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+
+	entry.State = state
+	m.limited[ip] = entry
+	return true
+}
+
+// returns the limit for the IP, if any.
+func (m *MemoryStore) GetLimited(ip string) (BlockedEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.limited[strings.TrimSpace(ip)]
+	return entry, ok
+}
+
+// forgets the limit for the IP.
+func (m *MemoryStore) RemoveLimited(ip string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.limited, strings.TrimSpace(ip))
+}
+
+// returns the limits sorted by IP.
+func (m *MemoryStore) ListLimited() []BlockedEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make([]BlockedEntry, 0, len(m.limited))
+	for _, entry := range m.limited {
+		out = append(out, entry)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].IP < out[j].IP })
+	return out
+}
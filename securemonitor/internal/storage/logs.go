@@ -1,28 +1,121 @@
 package storage
 
-//  logs buffer (ring buffer).
-var (
-	recentLogs  []string
-	maxLogsSize = 200
+import (
+	"strings"
+	"time"
 )
 
-//  appends a line to the recent logs buffer.
-func AddLog(line string) {
-	storeMu.Lock()
-	defer storeMu.Unlock()
+// LogEntry is one internal event. Message keeps the human-readable line
+// ("[SSH] 3 new failed logins from 203.0.113.7 (total=5)"); the other
+// fields carry the same information for filtering.
+type LogEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	Level     string    `json:"level"`     // info | warn | error
+	Component string    `json:"component"` // ssh, ftp, apache, firewall, scan, api, sim
+	Event     string    `json:"event"`     // e.g. failed_logins, block, unblock, reconcile
+	IP        string    `json:"ip,omitempty"`
+	Host      string    `json:"host,omitempty"` // hosts that reported the events (syslog)
+	Fields    Fields    `json:"fields,omitempty"`
+	Message   string    `json:"message"`
+}
+
+// Fields holds the key/value details of a log entry.
+type Fields map[string]any
+
+// log levels.
+const (
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+)
+
+// returns the text form of the entry.
+func (e LogEntry) String() string {
+	return e.Message
+}
 
-	recentLogs = append(recentLogs, line)
-	if len(recentLogs) > maxLogsSize {
-		recentLogs = recentLogs[len(recentLogs)-maxLogsSize:]
+// LogQuery selects log entries. Zero fields match everything.
+type LogQuery struct {
+	Since     time.Time
+	Level     string
+	Component string
+	Event     string
+	IP        string
+	Limit     int // newest Limit matches; 0 = all
+}
+
+// size of the recent logs buffer (ring buffer).
+const maxLogsSize = 200
+
+// appends an entry to the recent logs buffer, stamping it now and
+// defaulting the level to info.
+func (m *MemoryStore) Log(e LogEntry) {
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
 	}
+	if e.Level == "" {
+		e.Level = LevelInfo
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.logs = append(m.logs, e)
+	if len(m.logs) > maxLogsSize {
+		m.logs = m.logs[len(m.logs)-maxLogsSize:]
+	}
+}
+
+// appends a free-text line. The component is taken from a leading
+// "[SSH]"-style tag when there is one.
+func (m *MemoryStore) AddLog(line string) {
+	e := LogEntry{Event: "message", Message: line}
+	if strings.HasPrefix(line, "[") {
+		if end := strings.Index(line, "]"); end > 0 {
+			e.Component = strings.ToLower(line[1:end])
+		}
+	}
+	m.Log(e)
 }
 
-//  returns a copy of the recent log buffer.
-func GetLogs() []string {
-	storeMu.Lock()
-	defer storeMu.Unlock()
+// returns the recent logs in their text form.
+func (m *MemoryStore) GetLogs() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	out := make([]string, len(recentLogs))
-	copy(out, recentLogs)
+	out := make([]string, len(m.logs))
+	for i, e := range m.logs {
+		out[i] = e.Message
+	}
+	return out
+}
+
+// returns the recent log entries matching q, oldest first.
+func (m *MemoryStore) QueryLogs(q LogQuery) []LogEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := []LogEntry{}
+	for _, e := range m.logs {
+		if !q.Since.IsZero() && e.Timestamp.Before(q.Since) {
+			continue
+		}
+		if q.Level != "" && !strings.EqualFold(e.Level, q.Level) {
+			continue
+		}
+		if q.Component != "" && !strings.EqualFold(e.Component, q.Component) {
+			continue
+		}
+		if q.Event != "" && !strings.EqualFold(e.Event, q.Event) {
+			continue
+		}
+		if q.IP != "" && e.IP != q.IP {
+			continue
+		}
+		out = append(out, e)
+	}
+	if q.Limit > 0 && len(out) > q.Limit {
+		out = out[len(out)-q.Limit:]
+	}
 	return out
 }
@@ -0,0 +1,62 @@
+package storage
+
+import "time"
+
+// MatchedLine is a raw log line a parser counted against an IP.
+type MatchedLine struct {
+	Time    time.Time `json:"time"`
+	Service string    `json:"service"`
+	Host    string    `json:"host,omitempty"` // that logged the line, for remote sources
+	Line    string    `json:"line"`
+}
+
+const (
+	maxMatchedPerIP = 50    // newest lines kept per IP
+	maxMatchedIPs   = 10000 // least recently matched IPs are forgotten first
+)
+
+type matchedLines struct {
+	ip    string
+	lines []MatchedLine
+}
+
+// remembers a raw line that was counted against the IP. A zero Time is
+// set to now.
+func (m *MemoryStore) AddMatchedLine(ip string, ml MatchedLine) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if ml.Time.IsZero() {
+		ml.Time = time.Now()
+	}
+
+	if el, ok := m.matchedByIP[ip]; ok {
+		entry := el.Value.(*matchedLines)
+		entry.lines = append(entry.lines, ml)
+		if len(entry.lines) > maxMatchedPerIP {
+			entry.lines = entry.lines[len(entry.lines)-maxMatchedPerIP:]
+		}
+		m.matchedOrder.MoveToFront(el)
+		return
+	}
+
+	m.matchedByIP[ip] = m.matchedOrder.PushFront(&matchedLines{ip: ip, lines: []MatchedLine{ml}})
+	if m.matchedOrder.Len() > maxMatchedIPs {
+		oldest := m.matchedOrder.Back()
+		m.matchedOrder.Remove(oldest)
+		delete(m.matchedByIP, oldest.Value.(*matchedLines).ip)
+	}
+}
+
+// returns the raw lines remembered for the IP, oldest first.
+func (m *MemoryStore) GetMatchedLines(ip string) []MatchedLine {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.matchedByIP[ip]
+	if !ok {
+		return []MatchedLine{}
+	}
+	lines := el.Value.(*matchedLines).lines
+	return append([]MatchedLine(nil), lines...)
+}
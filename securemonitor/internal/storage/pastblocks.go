@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"sort"
+	"time"
+)
+
+// PastBlock is a block that was lifted.
+type PastBlock struct {
+	BlockedEntry
+	LiftedAt time.Time `json:"lifted_at"`
+}
+
+// newest lifted blocks kept per IP.
+const maxPastBlocks = 20
+
+// appends a lifted block to the IP's history. Callers must hold m.mu.
+func (m *MemoryStore) addPastBlock(p PastBlock) {
+	p.State = ""
+	h := append(m.pastBlocks[p.IP], p)
+	if len(h) > maxPastBlocks {
+		h = h[len(h)-maxPastBlocks:]
+	}
+	m.pastBlocks[p.IP] = h
+}
+
+// returns the lifted blocks of the IP, oldest first.
+func (m *MemoryStore) PastBlocks(ip string) []PastBlock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]PastBlock{}, m.pastBlocks[ip]...)
+}
+
+// returns every IP's lifted blocks, oldest first, dropping those lifted
+// before the strike window when one is set. Callers must hold m.mu.
+func (m *MemoryStore) livePastBlocks(now time.Time) []PastBlock {
+	var out []PastBlock
+	for ip, h := range m.pastBlocks {
+		if w := m.strikePolicy.Window; w > 0 {
+			cutoff := now.Add(-w)
+			i := sort.Search(len(h), func(i int) bool { return h[i].LiftedAt.After(cutoff) })
+			h = h[i:]
+			if len(h) == 0 {
+				delete(m.pastBlocks, ip)
+				continue
+			}
+			m.pastBlocks[ip] = h
+		}
+		out = append(out, h...)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].IP != out[j].IP {
+			return out[i].IP < out[j].IP
+		}
+		return out[i].LiftedAt.Before(out[j].LiftedAt)
+	})
+	return out
+}
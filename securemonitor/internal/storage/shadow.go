@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// records a block that would have been applied and returns the entry.
+// Like AddBlocked, a repeated decision only widens the scope.
+func (m *MemoryStore) AddShadowBlocked(e BlockedEntry) BlockedEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ip := strings.TrimSpace(e.IP)
+	e.IP = ip
+	if len(e.Ports) == 0 {
+		e.Scope = "host"
+	} else {
+		e.Scope = "service"
+	}
+
+	if entry, ok := m.shadow[ip]; ok {
+		entry = mergeScope(entry, e)
+		m.shadow[ip] = entry
+		return entry
+	}
+
+	m.shadowStrikes[ip]++
+	e.BlockedAt = time.Now()
+	e.Strikes = m.shadowStrikes[ip]
+	m.shadow[ip] = e
+	return e
+}
+
+// returns the shadow block for the IP, if any.
+func (m *MemoryStore) GetShadowBlocked(ip string) (BlockedEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.shadow[strings.TrimSpace(ip)]
+	return entry, ok
+}
+
+// drops a shadow block (it would have been unblocked).
+func (m *MemoryStore) RemoveShadowBlocked(ip string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.shadow, strings.TrimSpace(ip))
+}
+
+// returns the shadow blocks sorted by IP.
+func (m *MemoryStore) ListShadowBlocked() []BlockedEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make([]BlockedEntry, 0, len(m.shadow))
+	for _, entry := range m.shadow {
+		out = append(out, entry)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].IP < out[j].IP })
+	return out
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// BlockStore keeps the blocks, rate limits and dry-run blocks of
+// addresses, and the strike history that sizes their bans.
+type BlockStore interface {
+	AddBlocked(e BlockedEntry) BlockedEntry
+	SetBlockState(ip, state string, from ...string) bool
+	GetBlocked(ip string) (BlockedEntry, bool)
+	NextStrikes(ip string) int
+	RemoveBlocked(ip string)
+	ListBlocked() []string
+	ListBlockedEntries() []BlockedEntry
+
+	AddLimited(e BlockedEntry) BlockedEntry
+	SetLimitState(ip, state string, from ...string) bool
+	GetLimited(ip string) (BlockedEntry, bool)
+	RemoveLimited(ip string)
+	ListLimited() []BlockedEntry
+
+	AddShadowBlocked(e BlockedEntry) BlockedEntry
+	GetShadowBlocked(ip string) (BlockedEntry, bool)
+	RemoveShadowBlocked(ip string)
+	ListShadowBlocked() []BlockedEntry
+
+	SetStrikePolicy(p StrikePolicy)
+	StrikeHistory(ip string) []time.Time
+	PastBlocks(ip string) []PastBlock
+}
+
+// AlertStore keeps the alert history.
+type AlertStore interface {
+	AddAlert(a Alert) Alert
+	GetAlerts() []Alert
+	ScanAlerts(q AlertQuery, fn func(Alert) error) error
+	QueryAlerts(q AlertQuery) ([]Alert, uint64, error)
+}
+
+// LogStore keeps the internal event log and the raw lines counted
+// against each address.
+type LogStore interface {
+	Log(e LogEntry)
+	AddLog(line string)
+	GetLogs() []string
+	QueryLogs(q LogQuery) []LogEntry
+	AddMatchedLine(ip string, ml MatchedLine)
+	GetMatchedLines(ip string) []MatchedLine
+}
+
+// AuditStore keeps the trail of operator actions.
+type AuditStore interface {
+	AddAudit(rec AuditRecord) AuditRecord
+	QueryAudit(q AuditQuery) ([]AuditRecord, uint64, error)
+}
+
+// Store is everything the monitor and the API remember. The monitor and
+// API packages are handed one at startup; MemoryStore keeps it all in
+// memory and DiskStore persists it.
+type Store interface {
+	BlockStore
+	AlertStore
+	LogStore
+	AuditStore
+
+	// Save persists the block state; a no-op for stores without a disk.
+	Save() error
+	// Close releases the files held by the store.
+	Close() error
+}
+
+// MemoryStore is a Store that forgets everything on exit. Alerts and
+// logs are bounded ring buffers.
+type MemoryStore struct {
+	mu sync.Mutex
+
+	blocked map[string]BlockedEntry
+
+	// rate-limited sources. They reuse BlockedEntry (BlockedAt is when the
+	// limit started) but Strikes counts the offences seen while limited,
+	// which drives the escalation to a full block.
+	limited map[string]BlockedEntry
+
+	// shadow blocks: decisions taken in dry-run mode. They follow the same
+	// strike escalation as real blocks but are kept apart so they never
+	// mix with what is actually enforced.
+	shadow        map[string]BlockedEntry
+	shadowStrikes map[string]int
+
+	strikePolicy  StrikePolicy
+	strikeHistory map[string][]time.Time // ip -> strike times, oldest first
+	pastBlocks    map[string][]PastBlock // ip -> oldest first
+
+	alerts      []Alert
+	nextAlertID uint64
+
+	logs         []LogEntry
+	matchedByIP  map[string]*list.Element // -> *matchedLines
+	matchedOrder *list.List               // most recently matched first
+
+	audit       []AuditRecord
+	nextAuditID uint64
+}
+
+// returns an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		blocked:       make(map[string]BlockedEntry),
+		limited:       make(map[string]BlockedEntry),
+		shadow:        make(map[string]BlockedEntry),
+		shadowStrikes: make(map[string]int),
+		strikeHistory: make(map[string][]time.Time),
+		pastBlocks:    make(map[string][]PastBlock),
+		nextAlertID:   1,
+		matchedByIP:   make(map[string]*list.Element),
+		matchedOrder:  list.New(),
+		nextAuditID:   1,
+	}
+}
+
+// nothing to persist.
+func (m *MemoryStore) Save() error { return nil }
+
+// nothing to release.
+func (m *MemoryStore) Close() error { return nil }
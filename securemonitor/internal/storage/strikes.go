@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"sort"
+	"time"
+)
+
+// StrikePolicy decides how long strikes count against an address.
+type StrikePolicy struct {
+	// Window only counts strikes this recent; 0 counts them all.
+	Window time.Duration
+	// Decay forgives the oldest strike for every full Decay period
+	// without a new one; 0 never forgives.
+	Decay time.Duration
+}
+
+// sets the decay policy applied from now on, including to history loaded
+// from the block database.
+func (m *MemoryStore) SetStrikePolicy(p StrikePolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.strikePolicy = p
+}
+
+// returns the strikes of the IP inside the window at now, decay not
+// applied. Callers must hold m.mu.
+func (m *MemoryStore) windowStrikes(ip string, now time.Time) []time.Time {
+	times := m.strikeHistory[ip]
+	if w := m.strikePolicy.Window; w > 0 && len(times) > 0 {
+		cutoff := now.Add(-w)
+		i := sort.Search(len(times), func(i int) bool { return times[i].After(cutoff) })
+		times = times[i:]
+	}
+	return times
+}
+
+// returns the strikes of the IP still counting at now. Decay is measured
+// from the last strike, which only moves when a new strike is recorded,
+// so the forgiven strikes stay in the history until then: reading never
+// forgives twice. Histories with nothing left are dropped. Callers must
+// hold m.mu.
+func (m *MemoryStore) liveStrikes(ip string, now time.Time) []time.Time {
+	times := m.windowStrikes(ip, now)
+
+	if d := m.strikePolicy.Decay; d > 0 && len(times) > 0 {
+		quiet := now.Sub(times[len(times)-1])
+		forgiven := int(quiet / d)
+		if forgiven >= len(times) {
+			times = nil
+		} else if forgiven > 0 {
+			times = times[forgiven:]
+		}
+	}
+
+	if len(times) == 0 {
+		delete(m.strikeHistory, ip)
+		return nil
+	}
+	return times
+}
+
+// records a strike for the IP at now and returns the live count. The
+// new strike restarts the decay, so the strikes forgiven so far are
+// dropped for good. Callers must hold m.mu.
+func (m *MemoryStore) addStrike(ip string, now time.Time) int {
+	live := m.liveStrikes(ip, now)
+	times := make([]time.Time, 0, len(live)+1)
+	times = append(append(times, live...), now)
+	m.strikeHistory[ip] = times
+	return len(times)
+}
+
+// returns the times of the strikes still counting against the IP.
+func (m *MemoryStore) StrikeHistory(ip string) []time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	times := m.liveStrikes(ip, time.Now())
+	return append([]time.Time(nil), times...)
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLiveStrikes(t *testing.T) {
+	const day = 24 * time.Hour
+	// saveBlockDB works at the current time.
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		policy StrikePolicy
+		ages   []time.Duration // how long ago each strike was, oldest first
+		want   int
+	}{
+		{"no policy", StrikePolicy{}, []time.Duration{300 * day, 10 * day, day}, 3},
+		{"inside window", StrikePolicy{Window: 90 * day}, []time.Duration{30 * day, 10 * day}, 2},
+		{"outside window", StrikePolicy{Window: 90 * day}, []time.Duration{120 * day, 100 * day, 10 * day}, 1},
+		{"quiet less than decay", StrikePolicy{Decay: 14 * day}, []time.Duration{20 * day, 15 * day, 13 * day}, 3},
+		{"one decay period", StrikePolicy{Decay: 14 * day}, []time.Duration{30 * day, 20 * day, 15 * day}, 2},
+		{"two decay periods", StrikePolicy{Decay: 14 * day}, []time.Duration{40 * day, 35 * day, 30 * day}, 1},
+		{"all forgiven", StrikePolicy{Decay: 14 * day}, []time.Duration{60 * day, 50 * day, 45 * day}, 0},
+		{"window then decay", StrikePolicy{Window: 90 * day, Decay: 14 * day}, []time.Duration{100 * day, 40 * day, 35 * day, 15 * day}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemoryStore()
+			m.SetStrikePolicy(tt.policy)
+			for _, age := range tt.ages {
+				m.strikeHistory["192.0.2.1"] = append(m.strikeHistory["192.0.2.1"], now.Add(-age))
+			}
+
+			// reading must not forgive again.
+			for call := 1; call <= 3; call++ {
+				if got := len(m.liveStrikes("192.0.2.1", now)); got != tt.want {
+					t.Fatalf("call %d: live strikes = %d, want %d", call, got, tt.want)
+				}
+			}
+
+			// neither must a save and reload.
+			path := filepath.Join(t.TempDir(), "blocked_db.jsonl")
+			if err := m.saveBlockDB(path); err != nil {
+				t.Fatalf("save: %v", err)
+			}
+			loaded := NewMemoryStore()
+			loaded.SetStrikePolicy(tt.policy)
+			if err := loaded.loadBlockDB(path, ""); err != nil {
+				t.Fatalf("load: %v", err)
+			}
+			if got := len(loaded.liveStrikes("192.0.2.1", now)); got != tt.want {
+				t.Errorf("after reload: live strikes = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddStrikeRestartsDecay(t *testing.T) {
+	const day = 24 * time.Hour
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	m := NewMemoryStore()
+	m.SetStrikePolicy(StrikePolicy{Decay: 14 * day})
+	m.strikeHistory["192.0.2.1"] = []time.Time{now.Add(-40 * day), now.Add(-35 * day), now.Add(-30 * day)}
+
+	// two strikes were forgiven; the new one counts on top of the last.
+	if got := m.addStrike("192.0.2.1", now); got != 2 {
+		t.Fatalf("strikes after a new one = %d, want 2", got)
+	}
+	if got := len(m.liveStrikes("192.0.2.1", now.Add(day))); got != 2 {
+		t.Errorf("strikes a day later = %d, want 2", got)
+	}
+	if got := len(m.liveStrikes("192.0.2.1", now.Add(15*day))); got != 1 {
+		t.Errorf("strikes one decay period later = %d, want 1", got)
+	}
+}
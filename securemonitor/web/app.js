@@ -14,6 +14,7 @@ const dashboardState = {
   logs: [],
   alerts: [],
   blocked: [],
+  wouldBlock: [],
 };
 
 let consecutiveFailures = 0;
@@ -304,6 +305,7 @@ function renderAlerts(alerts) {
     const sevCls = severityClass(sev);
     const svc = serviceLabel(alert.service);
     const ip = alert.ip || "—";
+    const host = alert.host || "—";
     const msg = alert.message || "";
     const country = alert.country || "—";
 
@@ -321,6 +323,7 @@ function renderAlerts(alerts) {
         <span class="service-tag">${svc}</span>
       </td>
       <td data-label="IP">${ip}</td>
+      <td data-label="Host">${host}</td>
       <td data-label="País">${country}</td>
       <td data-label="Severidad">
         <span class="severity-pill ${sevCls}">
@@ -380,6 +383,31 @@ function applyBlocked(ips) {
   });
 }
 
+// render dry-run decisions; nothing to unblock there
+function applyWouldBlock(ips) {
+  const list = document.getElementById("wouldBlockList");
+  const empty = document.getElementById("wouldBlockEmptyMsg");
+  if (!list) return;
+
+  list.innerHTML = "";
+
+  const arr = Array.isArray(ips) ? ips : [];
+
+  if (empty) {
+    empty.style.display = arr.length === 0 ? "block" : "none";
+  }
+
+  [...arr].sort((a, b) => a.localeCompare(b, "en")).forEach((ip) => {
+    const li = document.createElement("li");
+
+    const span = document.createElement("span");
+    span.textContent = ip;
+
+    li.appendChild(span);
+    list.appendChild(li);
+  });
+}
+
 // main snapshot loop
 async function refreshDashboard() {
   try {
@@ -391,12 +419,14 @@ async function refreshDashboard() {
     dashboardState.logs    = Array.isArray(data.logs)    ? data.logs    : dashboardState.logs;
     dashboardState.alerts  = Array.isArray(data.alerts)  ? data.alerts  : dashboardState.alerts;
     dashboardState.blocked = Array.isArray(data.blocked) ? data.blocked : dashboardState.blocked;
+    dashboardState.wouldBlock = Array.isArray(data.would_block) ? data.would_block : dashboardState.wouldBlock;
 
     applyStatus(dashboardState.status);
     applyStats(dashboardState.stats);
     applyLogs(dashboardState.logs);
     applyAlerts(dashboardState.alerts);
     applyBlocked(dashboardState.blocked);
+    applyWouldBlock(dashboardState.wouldBlock);
   } catch (e) {
     consecutiveFailures++;
     applyConnectionDegraded(consecutiveFailures);